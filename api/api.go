@@ -1,7 +1,11 @@
 package api
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"strings"
 	"time"
 
@@ -18,11 +22,182 @@ func (h Hash) String() string {
 	return b.String()
 }
 
+var ErrAlgorithm = errors.New("invalid hash algorithm")
+
+type Algorithm int16
+
+const (
+	AlgorithmSHA1 Algorithm = iota
+	AlgorithmSHA256
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmSHA1:
+		return "sha1"
+	case AlgorithmSHA256:
+		return "sha256"
+	default:
+		return "unknown"
+	}
+}
+
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case AlgorithmSHA256:
+		return sha256.New()
+	default:
+		return sha1.New()
+	}
+}
+
+func (a Algorithm) Strong() bool {
+	return a != AlgorithmSHA1
+}
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	for a := AlgorithmSHA1; a <= AlgorithmSHA256; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, ErrAlgorithm
+}
+
+type Kind int16
+
+const (
+	KindStorage Kind = iota
+	KindInline
+	KindPacked
+)
+
+type Tier int16
+
+const (
+	TierHot Tier = iota
+	TierCold
+)
+
+type Block struct {
+	ID        uuid.UUID `json:"id"`
+	Hash      Hash      `json:"hash,omitempty"`
+	Size      int64     `json:"size"`
+	Codec     Codec     `json:"codec,omitempty"`
+	Algorithm Algorithm `json:"algorithm,omitempty"`
+	Kind      Kind      `json:"kind,omitempty"`
+	Payload   []byte    `json:"payload,omitempty"`
+	Pack      uuid.UUID `json:"pack,omitempty" db:"pack_id"`
+	Offset    int64     `json:"offset,omitempty" db:"pack_offset"`
+	Length    int64     `json:"length,omitempty" db:"pack_length"`
+	Tier      Tier      `json:"tier,omitempty"`
+}
+
+type Info struct {
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+type Pack struct {
+	ID   uuid.UUID `json:"id"`
+	Size int64     `json:"size"`
+	Live int64     `json:"live"`
+}
+
+// Snapshot is a named point-in-time copy of the files under a prefix, it pins their blocks until released.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Prefix  string    `json:"prefix"`
+	Files   int       `json:"files"`
+	Created time.Time `json:"created"`
+}
+
+var (
+	ErrPrecondition = errors.New("precondition failed")
+	ErrNotExist     = errors.New("file does not exist")
+	ErrExist        = errors.New("file already exists")
+)
+
+// Any is the chain a conditional update matches when the file has any content at all, as If-Match: * does.
+var Any = uuid.UUID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Match tells whether a file holding the chain satisfies the condition of an update, uuid.Nil is no condition.
+func Match(chain, match uuid.UUID) bool {
+	switch match {
+	case uuid.Nil:
+		return true
+	case Any:
+		return chain != uuid.Nil
+	default:
+		return chain == match
+	}
+}
+
+// Object describes the published content of a key, Created is when the key was first published and Modified when
+// its content was.
+type Object struct {
+	Key      string            `json:"key"`
+	Version  int               `json:"version"`
+	Chain    uuid.UUID         `json:"chain"`
+	ETag     string            `json:"etag,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Size     int64             `json:"size"`
+	Blocks   []Block           `json:"blocks,omitempty"`
+	Created  time.Time         `json:"created"`
+	Modified time.Time         `json:"modified"`
+	Class    Tier              `json:"class"`
+}
+
+// Complete derives the entity tag, size and storage class.
+func (o *Object) Complete() {
+	o.ETag, o.Size, o.Class = ETag(o.Chain), 0, TierHot
+	for _, b := range o.Blocks {
+		o.Size += b.Size
+		if b.Kind == KindStorage && b.Tier > o.Class {
+			o.Class = b.Tier
+		}
+	}
+}
+
+func ETag(chain uuid.UUID) string {
+	return `"` + chain.String() + `"`
+}
+
+func Split(blocks []Block) (ids []uuid.UUID, sizes []int64) {
+	for _, b := range blocks {
+		ids = append(ids, b.ID)
+		sizes = append(sizes, b.Size)
+	}
+	return
+}
+
+type Codec int16
+
+const (
+	CodecNone Codec = iota
+	CodecZstd
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
 type File struct {
-	Chains []uuid.UUID `json:"chains,omitempty"`
-	Blocks []uuid.UUID `json:"blocks,omitempty"`
-	Hashes []Hash      `json:"hashes,omitempty"`
-	Sizes  []int64     `json:"sizes,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Chains    []uuid.UUID `json:"chains,omitempty"`
+	Blocks    []uuid.UUID `json:"blocks,omitempty"`
+	Hashes    []Hash      `json:"hashes,omitempty"`
+	Sizes     []int64     `json:"sizes,omitempty"`
+	Algorithm Algorithm   `json:"algorithm,omitempty"`
 }
 
 type FileReply struct {
@@ -35,21 +210,31 @@ type Echo struct {
 }
 
 type Head struct {
-	Name string `json:"name,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
 }
 
 type HeadReply struct {
+	Object
+}
+
+type EventType string
+
+const (
+	EventCreated      EventType = "created"
+	EventOverwritten  EventType = "overwritten"
+	EventDeleted      EventType = "deleted"
+	EventDeduplicated EventType = "deduplicated"
+	EventCorrupted    EventType = "corrupted"
+	EventRenamed      EventType = "renamed"
+)
+
+type Event struct {
+	Type   EventType   `json:"type"`
 	Name   string      `json:"name,omitempty"`
+	From   string      `json:"from,omitempty"`
 	Time   time.Time   `json:"time"`
 	Size   int64       `json:"size,omitempty"`
+	Chain  uuid.UUID   `json:"chain,omitempty"`
 	Blocks []uuid.UUID `json:"blocks,omitempty"`
-	Sizes  []int64     `json:"sizes,omitempty"`
-}
-
-func (x *HeadReply) GetLength() int64 {
-	var size int64
-	for i := range x.Sizes {
-		size += x.Sizes[i]
-	}
-	return size
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/pshvedko/nocopy/broker/exchange"
 	"github.com/pshvedko/nocopy/broker/message"
+	"github.com/pshvedko/nocopy/broker/transport/mem"
 	"github.com/pshvedko/nocopy/broker/transport/nats"
 )
 
@@ -22,6 +23,7 @@ type Broker interface {
 	Backward(context.Context, message.Message, ...exchange.Option) (uuid.UUID, error)
 	Send(context.Context, message.Message, ...exchange.Option) (uuid.UUID, error)
 	Listen(context.Context, string, ...string) error
+	Group(context.Context, string, string) error
 	Topic(int) (int, string)
 	Finish()
 	Shutdown()
@@ -47,6 +49,8 @@ func NewTransport(name string, ident string) (exchange.Transport, error) {
 	switch u.Scheme {
 	case "nats":
 		return nats.New(u, ident)
+	case "mem":
+		return mem.New(u, ident)
 	default:
 		return nil, errors.New("invalid broker scheme")
 	}
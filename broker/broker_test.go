@@ -51,6 +51,18 @@ func TestExchange(t *testing.T) {
 	t.Run("Service", s.TestService)
 }
 
+func TestMemExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Minute)
+	defer cancel()
+	s := Suit{
+		url:      "mem://broker",
+		ctx:      ctx,
+		messages: make(chan message.Message, 1),
+	}
+	slog.SetDefault(NewLogger(t))
+	t.Run("Service", s.TestService)
+}
+
 func (s *Suit) TestService(t *testing.T) {
 	var bb []Broker
 
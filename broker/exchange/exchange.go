@@ -3,6 +3,7 @@ package exchange
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 
 	"github.com/google/uuid"
@@ -20,6 +21,7 @@ type Transport interface {
 	Flush() error
 	Subscribe(context.Context, string, message.Decoder) (Subscription, error)
 	QueueSubscribe(context.Context, string, string, message.Decoder) (Subscription, error)
+	GroupSubscribe(context.Context, string, string, message.Decoder) (Subscription, error)
 	Publish(context.Context, message.Message, message.Encoder) error
 	Unsubscribe(Topic) error
 	Close()
@@ -46,6 +48,7 @@ type Key struct {
 
 type Exchange struct {
 	transport Transport
+	mutex     sync.RWMutex
 	topic     [2][]Topic
 	child     internal.Map[context.Context, context.CancelFunc]
 	reply     internal.Map[Key, chan<- message.Message]
@@ -71,6 +74,8 @@ func (e *Exchange) UseOptions(options ...Option) {
 }
 
 func (e *Exchange) Topic(i int) (int, string) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
 	n := len(e.topic[0]) - 1
 	if i < 0 || i > n {
 		i = n
@@ -111,11 +116,17 @@ func (e *Exchange) Catch(method string, catcher message.CatchFunc) {
 	e.catcher[method] = catcher
 }
 
+func (e *Exchange) From() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.topic[0][0].subject
+}
+
 func (e *Exchange) Message(ctx context.Context, to string, method string, body message.Body, options ...Option) (uuid.UUID, error) {
 	m := message.New().
 		WithMethod(method).
 		WithBody(body).
-		WithFrom(e.topic[0][0].subject).
+		WithFrom(e.From()).
 		WithTo(to).
 		Build()
 
@@ -128,7 +139,7 @@ func (e *Exchange) Request(ctx context.Context, to string, method string, body m
 		WithType(message.Request).
 		WithMethod(method).
 		WithBody(body).
-		WithFrom(e.topic[0][0].subject).
+		WithFrom(e.From()).
 		WithTo(to).
 		Build()
 	o, m := e.Apply(m, options...)
@@ -218,10 +229,12 @@ func (e *Exchange) Listen(ctx context.Context, on string, to ...string) error {
 			return err
 		}
 
+		e.mutex.Lock()
 		e.topic[0] = append(e.topic[0], Topic{
 			subject:      at,
 			Subscription: s,
 		})
+		e.mutex.Unlock()
 
 		if len(to) == 0 {
 			break
@@ -232,11 +245,13 @@ func (e *Exchange) Listen(ctx context.Context, on string, to ...string) error {
 			return err
 		}
 
+		e.mutex.Lock()
 		e.topic[1] = append(e.topic[1], Topic{
 			subject:      at,
 			wide:         true,
 			Subscription: s,
 		})
+		e.mutex.Unlock()
 
 		at = fmt.Sprint(at, ".", to[0])
 		to = to[1:]
@@ -245,6 +260,24 @@ func (e *Exchange) Listen(ctx context.Context, on string, to ...string) error {
 	return e.transport.Flush()
 }
 
+// Group listens to the broadcasts of the topic within the queue, each broadcast is delivered to one of its members.
+func (e *Exchange) Group(ctx context.Context, at string, queue string) error {
+	s, err := e.transport.GroupSubscribe(ctx, at, queue, e)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	e.topic[1] = append(e.topic[1], Topic{
+		subject:      at,
+		wide:         true,
+		Subscription: s,
+	})
+	e.mutex.Unlock()
+
+	return e.transport.Flush()
+}
+
 func (e *Exchange) Do(ctx context.Context, m message.Message) {
 	ctx, cancel := context.WithCancel(context.WithValue(ctx, m.Type(), m.ID()))
 
@@ -300,6 +333,8 @@ func (e *Exchange) Finish() {
 	if !e.finish.CompareAndSwap(false, true) {
 		return
 	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	for i, topic := range &e.topic {
 		for _, t := range topic {
 			_ = e.transport.Unsubscribe(t)
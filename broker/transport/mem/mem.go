@@ -0,0 +1,150 @@
+package mem
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pshvedko/nocopy/broker/exchange"
+	"github.com/pshvedko/nocopy/broker/message"
+)
+
+// Bus routes messages between transports of the same host like nats does, without echo to the sender.
+type Bus struct {
+	sync.Mutex
+	subscriptions map[string][]*Subscription
+	next          map[string]int
+}
+
+type Subscription struct {
+	bus       *Bus
+	transport *Transport
+	subject   string
+	queue     string
+	ctx       context.Context
+	decoder   message.Decoder
+	closed    atomic.Bool
+}
+
+func (s *Subscription) Unsubscribe() error {
+	s.closed.Store(true)
+	s.bus.Lock()
+	defer s.bus.Unlock()
+	s.bus.subscriptions[s.subject] = slices.DeleteFunc(s.bus.subscriptions[s.subject], func(x *Subscription) bool {
+		return x == s
+	})
+	return nil
+}
+
+func (s *Subscription) Drain() error {
+	return s.Unsubscribe()
+}
+
+func (s *Subscription) Deliver(headers map[string][]string, bytes []byte) {
+	if s.closed.Load() {
+		return
+	}
+	ctx, m, err := s.decoder.Decode(s.ctx, headers, bytes)
+	if err != nil {
+		return
+	}
+	s.decoder.Do(ctx, m)
+}
+
+type Transport struct {
+	bus *Bus
+}
+
+func (t *Transport) Unsubscribe(topic exchange.Topic) error {
+	return topic.Unsubscribe()
+}
+
+const TopicPrefix = "%@"
+
+func (t *Transport) Publish(ctx context.Context, m message.Message, e message.Encoder) error {
+	headers, bytes, err := e.Encode(ctx, m)
+	if err != nil {
+		return err
+	}
+	subject := TopicPrefix[1:] + m.To()
+	if m.Type() == message.Broadcast {
+		subject = TopicPrefix[:1] + m.To()
+	}
+	for _, s := range t.bus.Route(t, subject) {
+		go s.Deliver(maps.Clone(headers), bytes)
+	}
+	return nil
+}
+
+func (b *Bus) Route(t *Transport, subject string) (targets []*Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	queues := map[string][]*Subscription{}
+	for _, s := range b.subscriptions[subject] {
+		if s.transport == t {
+			continue
+		}
+		if s.queue == "" {
+			targets = append(targets, s)
+			continue
+		}
+		queues[s.queue] = append(queues[s.queue], s)
+	}
+	for queue, group := range queues {
+		key := subject + " " + queue
+		targets = append(targets, group[b.next[key]%len(group)])
+		b.next[key]++
+	}
+	return
+}
+
+func (t *Transport) Subscribe(ctx context.Context, at string, d message.Decoder) (exchange.Subscription, error) {
+	return t.bus.Add(&Subscription{transport: t, subject: TopicPrefix[:1] + at, ctx: ctx, decoder: d}), nil
+}
+
+func (t *Transport) QueueSubscribe(ctx context.Context, at string, queue string, d message.Decoder) (exchange.Subscription, error) {
+	return t.bus.Add(&Subscription{transport: t, subject: TopicPrefix[1:] + at, queue: queue, ctx: ctx, decoder: d}), nil
+}
+
+func (t *Transport) GroupSubscribe(ctx context.Context, at string, queue string, d message.Decoder) (exchange.Subscription, error) {
+	return t.bus.Add(&Subscription{transport: t, subject: TopicPrefix[:1] + at, queue: queue, ctx: ctx, decoder: d}), nil
+}
+
+func (b *Bus) Add(s *Subscription) *Subscription {
+	b.Lock()
+	defer b.Unlock()
+	s.bus = b
+	b.subscriptions[s.subject] = append(b.subscriptions[s.subject], s)
+	return s
+}
+
+func (t *Transport) Flush() error {
+	return nil
+}
+
+func (t *Transport) Close() {
+	t.bus.Lock()
+	defer t.bus.Unlock()
+	for subject, subscriptions := range t.bus.subscriptions {
+		t.bus.subscriptions[subject] = slices.DeleteFunc(subscriptions, func(s *Subscription) bool {
+			if s.transport != t {
+				return false
+			}
+			s.closed.Store(true)
+			return true
+		})
+	}
+}
+
+var buses sync.Map
+
+func New(u *url.URL, _ string) (*Transport, error) {
+	b, _ := buses.LoadOrStore(u.Host, &Bus{
+		subscriptions: map[string][]*Subscription{},
+		next:          map[string]int{},
+	})
+	return &Transport{bus: b.(*Bus)}, nil
+}
@@ -51,6 +51,16 @@ func (t *Transport) QueueSubscribe(ctx context.Context, at string, queue string,
 	})
 }
 
+func (t *Transport) GroupSubscribe(ctx context.Context, at string, queue string, d message.Decoder) (exchange.Subscription, error) {
+	return t.conn.QueueSubscribe(TopicPrefix[:1]+at, queue, func(m *nats.Msg) {
+		ctx2, z, err := d.Decode(ctx, m.Header, m.Data)
+		if err != nil {
+			return
+		}
+		d.Do(ctx2, z)
+	})
+}
+
 func (t *Transport) Flush() error {
 	return t.conn.Flush()
 }
@@ -52,6 +52,23 @@ func main() {
 		},
 	}
 
+	var urlFlag string
+	var secretFlag string
+	var retryFlag int
+	var backoffFlag time.Duration
+	var timeoutFlag time.Duration
+
+	h := &cobra.Command{
+		Use:   "hook",
+		Short: "Event webhook",
+		PreRun: func(*cobra.Command, []string) {
+			context.AfterFunc(ctx, s.Stop)
+		},
+		RunE: func(*cobra.Command, []string) error {
+			return s.Hook(ctx, urlFlag, secretFlag, retryFlag, backoffFlag, timeoutFlag, pipeFlag)
+		},
+	}
+
 	c.PersistentFlags().VarP(log.NewLogLevel(&levelFlag, slog.LevelInfo), "level", "l", "log level")
 	c.PersistentFlags().StringVar(&pipeFlag, "pipe", "nats://nats", "message broker")
 	t.Flags().IntVarP(&concurrencyFlag, "concurrency", "c", 1, "concurrency")
@@ -59,6 +76,12 @@ func main() {
 	t.Flags().IntVarP(&pressureFlag, "pressure", "p", 64*1024, "pressure")
 	t.Flags().DurationVarP(&delayFlag, "delay", "d", 0, "delay")
 	c.AddCommand(t)
+	h.Flags().StringVar(&urlFlag, "url", "http://localhost/hook", "webhook url")
+	h.Flags().StringVar(&secretFlag, "secret", "", "webhook signature secret")
+	h.Flags().IntVar(&retryFlag, "retry", 5, "webhook retries")
+	h.Flags().DurationVar(&backoffFlag, "backoff", time.Second, "webhook retry backoff")
+	h.Flags().DurationVar(&timeoutFlag, "timeout", 10*time.Second, "webhook timeout")
+	c.AddCommand(h)
 
 	err := c.Execute()
 	if err != nil {
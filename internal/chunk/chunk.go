@@ -0,0 +1,122 @@
+package chunk
+
+import (
+	"errors"
+	"math/bits"
+
+	"github.com/pshvedko/nocopy/internal/io"
+)
+
+var ErrInvalidSize = errors.New("invalid chunk size")
+
+// Chunker cuts a stream into blocks. The last block is returned together with io.EOF, it may be empty.
+type Chunker interface {
+	Next() ([]byte, error)
+}
+
+type Fixed struct {
+	r    io.Reader
+	size int
+}
+
+func (c *Fixed) Next() ([]byte, error) {
+	b := make([]byte, c.size)
+	n, err := io.ReadBytes(c.r, b)
+	return b[:n], err
+}
+
+func NewFixed(r io.Reader, size int) (*Fixed, error) {
+	if size < 1 {
+		return nil, ErrInvalidSize
+	}
+	return &Fixed{r: r, size: size}, nil
+}
+
+var gear [256]uint64
+
+func init() {
+	x := uint64(0x6e6f636f7079)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+}
+
+// FastCDC cuts at content defined boundaries, so an insertion moves only the cut points around it.
+type FastCDC struct {
+	r     io.Reader
+	b     []byte
+	n     int
+	eof   bool
+	min   int
+	avg   int
+	max   int
+	small uint64
+	large uint64
+}
+
+func (c *FastCDC) Next() ([]byte, error) {
+	for !c.eof && c.n < c.max {
+		x, err := c.r.Read(c.b[c.n:c.max])
+		c.n += x
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	n := c.Cut(c.b[:c.n])
+	b := make([]byte, n)
+	copy(b, c.b[:n])
+	c.n = copy(c.b, c.b[n:c.n])
+	if c.eof && c.n == 0 {
+		return b, io.EOF
+	}
+	return b, nil
+}
+
+func (c *FastCDC) Cut(b []byte) int {
+	n := len(b)
+	if n <= c.min {
+		return n
+	}
+	m := min(c.avg, n)
+	var fp uint64
+	i := c.min
+	for ; i < m; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.small == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[b[i]]
+		if fp&c.large == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+func mask(n int) uint64 {
+	return (1<<n - 1) << (64 - n)
+}
+
+func NewFastCDC(r io.Reader, low, average, high int) (*FastCDC, error) {
+	if low < 1 || low > average || average > high {
+		return nil, ErrInvalidSize
+	}
+	n := bits.Len(uint(average)) - 1
+	return &FastCDC{
+		r:     r,
+		b:     make([]byte, high),
+		min:   low,
+		avg:   average,
+		max:   high,
+		small: mask(n + 1),
+		large: mask(max(n-1, 1)),
+	}, nil
+}
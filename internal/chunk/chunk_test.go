@@ -0,0 +1,81 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Split(t *testing.T, c Chunker) (chunks [][]byte) {
+	for {
+		b, err := c.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			require.NoError(t, err)
+		}
+		chunks = append(chunks, b)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestFixed(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		data string
+		want []string
+	}{
+		{name: "Empty", size: 4, data: "", want: []string{""}},
+		{name: "Short", size: 4, data: "123", want: []string{"123"}},
+		{name: "Exact", size: 4, data: "12345678", want: []string{"1234", "5678", ""}},
+		{name: "Long", size: 4, data: "123456789", want: []string{"1234", "5678", "9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewFixed(bytes.NewBufferString(tt.data), tt.size)
+			require.NoError(t, err)
+			var got []string
+			for _, b := range Split(t, c) {
+				got = append(got, string(b))
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFastCDC(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	split := func(data []byte) map[string]bool {
+		c, err := NewFastCDC(bytes.NewReader(data), 1024, 4096, 16384)
+		require.NoError(t, err)
+		chunks := Split(t, c)
+		require.Equal(t, data, bytes.Join(chunks, nil))
+		set := map[string]bool{}
+		for i, b := range chunks {
+			require.LessOrEqual(t, len(b), 16384)
+			if i < len(chunks)-1 {
+				require.GreaterOrEqual(t, len(b), 1024)
+			}
+			set[string(b)] = true
+		}
+		return set
+	}
+	a := split(data)
+	b := split(append([]byte{0}, data...))
+	var n int
+	for k := range b {
+		if a[k] {
+			n++
+		}
+	}
+	require.Greater(t, len(a), 64)
+	require.GreaterOrEqual(t, n, len(a)-2)
+	_, err := NewFastCDC(bytes.NewReader(data), 4096, 1024, 16384)
+	require.ErrorIs(t, err, ErrInvalidSize)
+}
@@ -2,77 +2,147 @@ package io
 
 import (
 	"bytes"
-	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Frame layout: zstd frames, one uint32 compressed length per frame, then the trailer.
+const (
+	FrameSize    = 64 << 10
+	FrameMagic   = "NCZ1"
+	FrameTrailer = 4 + 4 + 8 + len(FrameMagic)
 )
 
-type Compress struct {
-	r io.Reader
-	w interface {
-		io.WriteCloser
-		Flush() error
-	}
-	b bytes.Buffer
-	n *int64
+var ErrFrameFormat = errors.New("invalid frame format")
+
+var encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+var decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+func Compress(b []byte, frame int) []byte {
+	var index []byte
+	var out []byte
+	for o := 0; o < len(b); o += frame {
+		n := len(out)
+		out = encoder.EncodeAll(b[o:min(o+frame, len(b))], out)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(out)-n))
+	}
+	out = append(out, index...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(index)/4))
+	out = binary.BigEndian.AppendUint32(out, uint32(frame))
+	out = binary.BigEndian.AppendUint64(out, uint64(len(b)))
+	return append(out, FrameMagic...)
+}
+
+type Decompress struct {
+	r      io.ReadSeekCloser
+	frame  int64
+	size   int64
+	offset []int64
+	pos    int64
+	cur    int
+	buf    []byte
 }
 
-func (c *Compress) Read(p []byte) (n int, err error) {
-	for c.b.Len() == 0 {
-		var b [1024]byte
-		n, err = io.TeeReader(c.r, c.w).Read(b[:])
-		*c.n += int64(n)
+func (d *Decompress) Read(p []byte) (n int, err error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	f := int(d.pos / d.frame)
+	if f+1 >= len(d.offset) {
+		return 0, ErrFrameFormat
+	}
+	if f != d.cur {
+		_, err = d.r.Seek(d.offset[f], io.SeekStart)
 		if err != nil {
-			if err == io.EOF {
-				err = c.w.Close()
-				if err != nil {
-					return
-				}
-				break
-			}
 			return
 		}
-		err = c.w.Flush()
+		b := make([]byte, d.offset[f+1]-d.offset[f])
+		_, err = io.ReadFull(d.r, b)
 		if err != nil {
 			return
 		}
+		d.cur = -1
+		d.buf, err = decoder.DecodeAll(b, d.buf[:0])
+		if err != nil {
+			return
+		}
+		d.cur = f
 	}
-	return c.b.Read(p)
-}
-
-func TeeLimitReader(r io.Reader, m int64, w io.Writer) io.Reader {
-	return io.TeeReader(io.LimitReader(r, m), w)
-}
-
-func Compressor(r io.Reader, m int64, n *int64, w io.Writer) io.Reader {
-	var c Compress
-	c.r = io.TeeReader(io.LimitReader(r, m), w)
-	c.w = gzip.NewWriter(&c.b)
-	c.n = n
-	return &c
-}
-
-type Decompress struct {
-	z io.ReadCloser
-}
-
-func (d Decompress) Read(b []byte) (n int, err error) {
-	return d.z.Read(b)
+	o := d.pos - int64(f)*d.frame
+	if o >= int64(len(d.buf)) {
+		return 0, ErrFrameFormat
+	}
+	n = copy(p, d.buf[o:])
+	d.pos += int64(n)
+	return
 }
 
-func (d Decompress) Seek(o int64, _ int) (int64, error) {
-	return io.CopyN(io.Discard, d.z, o)
+func (d *Decompress) Seek(o int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		o += d.pos
+	case io.SeekEnd:
+		o += d.size
+	default:
+		return 0, ErrFrameFormat
+	}
+	if o < 0 {
+		return 0, ErrFrameFormat
+	}
+	d.pos = o
+	return o, nil
 }
 
-func (d Decompress) Close() error {
-	return d.z.Close()
+func (d *Decompress) Close() error {
+	return d.r.Close()
 }
 
 func Decompressor(r io.ReadSeekCloser) (io.ReadSeekCloser, error) {
-	z, err := gzip.NewReader(r)
+	end, err := r.Seek(-int64(FrameTrailer), io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var t [FrameTrailer]byte
+	_, err = io.ReadFull(r, t[:])
 	if err != nil {
 		return nil, err
 	}
-	return Decompress{
-		z: z,
-	}, nil
+	if !bytes.Equal(t[16:], []byte(FrameMagic)) {
+		return nil, ErrFrameFormat
+	}
+	n := int64(binary.BigEndian.Uint32(t[0:]))
+	d := &Decompress{
+		r:     r,
+		frame: int64(binary.BigEndian.Uint32(t[4:])),
+		size:  int64(binary.BigEndian.Uint64(t[8:])),
+		cur:   -1,
+	}
+	if d.frame == 0 || n*4 > end {
+		return nil, ErrFrameFormat
+	}
+	_, err = r.Seek(end-n*4, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	index := make([]byte, n*4)
+	_, err = io.ReadFull(r, index)
+	if err != nil {
+		return nil, err
+	}
+	d.offset = make([]int64, n+1)
+	for i := int64(0); i < n; i++ {
+		d.offset[i+1] = d.offset[i] + int64(binary.BigEndian.Uint32(index[i*4:]))
+	}
+	if d.offset[n] != end-n*4 {
+		return nil, ErrFrameFormat
+	}
+	return d, nil
+}
+
+func TeeLimitReader(r io.Reader, m int64, w io.Writer) io.Reader {
+	return io.TeeReader(io.LimitReader(r, m), w)
 }
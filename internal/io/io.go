@@ -7,6 +7,7 @@ import (
 )
 
 type ReadSeekCloser = io.ReadSeekCloser
+type Reader = io.Reader
 type ReadCloser = io.ReadCloser
 type Closer = io.Closer
 type Writer = io.Writer
@@ -14,6 +15,18 @@ type Writer = io.Writer
 var EOF = io.EOF
 var EOK error
 
+var ErrOffset = errors.New("invalid offset")
+var ErrUnexpectedEOF = io.ErrUnexpectedEOF
+
+const SeekStart = io.SeekStart
+const SeekEnd = io.SeekEnd
+
+var Copy = io.Copy
+var CopyN = io.CopyN
+var ReadAll = io.ReadAll
+var MultiWriter = io.MultiWriter
+var MultiReader = io.MultiReader
+
 func Compare(r1, r2 io.Reader) (bool, error) {
 	var b1, b2 [512]byte
 	for {
@@ -48,3 +61,52 @@ func CopyRange(w io.Writer, r io.ReadSeeker, o, n int64) (int64, error) {
 	}
 	return io.CopyN(w, r, n)
 }
+
+type Section struct {
+	r ReadSeekCloser
+	o int64
+	n int64
+	p int64
+}
+
+func (s *Section) Read(b []byte) (int, error) {
+	if s.p >= s.n {
+		return 0, EOF
+	}
+	if int64(len(b)) > s.n-s.p {
+		b = b[:s.n-s.p]
+	}
+	n, err := s.r.Read(b)
+	s.p += int64(n)
+	return n, err
+}
+
+func (s *Section) Seek(o int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		o += s.p
+	case io.SeekEnd:
+		o += s.n
+	}
+	if o < 0 {
+		return 0, ErrOffset
+	}
+	_, err := s.r.Seek(s.o+o, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	s.p = o
+	return o, nil
+}
+
+func (s *Section) Close() error {
+	return s.r.Close()
+}
+
+func NewSection(r ReadSeekCloser, o, n int64) (ReadSeekCloser, error) {
+	_, err := r.Seek(o, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return &Section{r: r, o: o, n: n}, nil
+}
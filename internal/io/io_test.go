@@ -1,6 +1,7 @@
 package io
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"strings"
@@ -75,3 +76,69 @@ func TestReadBytes(t *testing.T) {
 		})
 	}
 }
+
+type Buffer struct {
+	*bytes.Reader
+}
+
+func (b Buffer) Close() error { return nil }
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789abcdef", 1000))
+	for _, size := range []int{0, 1, 100, 1000, 16000} {
+		z := Compress(data[:size], 256)
+		r, err := Decompressor(Buffer{Reader: bytes.NewReader(z)})
+		if err != nil {
+			t.Fatalf("Decompressor() error = %v", err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(b, data[:size]) {
+			t.Fatalf("ReadAll() size = %v, error = %v", size, err)
+		}
+		for _, o := range []int64{0, 7, 255, 256, 257, 999} {
+			if o >= int64(size) {
+				continue
+			}
+			_, err = r.Seek(o, io.SeekStart)
+			if err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			var w bytes.Buffer
+			n, err := CopyRange(&w, r, 3, 10)
+			want := data[min(o+3, int64(size)):min(o+13, int64(size))]
+			if !bytes.Equal(w.Bytes(), want) || n != int64(len(want)) {
+				t.Errorf("CopyRange() size = %v, offset = %v, got %q, want %q, error = %v", size, o, w.Bytes(), want, err)
+			}
+		}
+	}
+	_, err := Decompressor(Buffer{Reader: bytes.NewReader(data[:100])})
+	if !errors.Is(err, ErrFrameFormat) {
+		t.Errorf("Decompressor() error = %v, want %v", err, ErrFrameFormat)
+	}
+}
+
+func TestSection(t *testing.T) {
+	data := []byte("0123456789abcdef")
+	z := Compress([]byte("packed"), 4)
+	pack := append(append(data[:5:5], z...), data[5:]...)
+	s, err := NewSection(Buffer{Reader: bytes.NewReader(pack)}, 5, int64(len(z)))
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	r, err := Decompressor(s)
+	if err != nil {
+		t.Fatalf("Decompressor() error = %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "packed" {
+		t.Errorf("ReadAll() got %q, error = %v", b, err)
+	}
+	s, err = NewSection(Buffer{Reader: bytes.NewReader(data)}, 10, 4)
+	if err != nil {
+		t.Fatalf("NewSection() error = %v", err)
+	}
+	b, err = io.ReadAll(s)
+	if err != nil || string(b) != "abcd" {
+		t.Errorf("ReadAll() got %q, error = %v", b, err)
+	}
+}
@@ -39,6 +39,11 @@ func (t Transport) QueueSubscribe(ctx context.Context, at string, by string, dec
 	return t.Transport.QueueSubscribe(ctx, at, by, Input{Decoder: decoder})
 }
 
+func (t Transport) GroupSubscribe(ctx context.Context, at string, by string, decoder message.Decoder) (exchange.Subscription, error) {
+	slog.Debug("LISTEN", "at", at, "wide", true, "by", by)
+	return t.Transport.GroupSubscribe(ctx, at, by, Input{Decoder: decoder})
+}
+
 func (t Transport) Publish(ctx context.Context, m message.Message, encoder message.Encoder) error {
 	out := slog.With("id", m.ID(), "by", m.Method(), "at", m.From(), "to", m.To(), "type", m.Type(), "return", m.Return())
 	err := t.Transport.Publish(ctx, m, encoder)
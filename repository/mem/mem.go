@@ -0,0 +1,546 @@
+package mem
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExist    = errors.New("already exists")
+)
+
+type Row struct {
+	api.Block
+	refer    int
+	updated  time.Time
+	checked  time.Time
+	corrupt  bool
+	accessed time.Time
+}
+
+type Chain struct {
+	blocks   []uuid.UUID
+	mime     string
+	metadata map[string]string
+	created  time.Time
+}
+
+type File struct {
+	id      uuid.UUID
+	path    string
+	chain   uuid.UUID
+	version int
+	created time.Time
+}
+
+type Snapshot struct {
+	prefix  string
+	created time.Time
+	files   map[string]*File
+}
+
+// Repository keeps the schema of the postgres repository in maps, it is shared by every New of the same host.
+type Repository struct {
+	sync.Mutex
+	files     map[string]*File
+	chains    map[uuid.UUID]*Chain
+	blocks    map[uuid.UUID]*Row
+	packs     map[uuid.UUID]*api.Pack
+	snapshots map[string]*Snapshot
+}
+
+func (r *Repository) Get(_ context.Context, path string) (object api.Object, err error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.Object(path, r.files[path]), nil
+}
+
+func (r *Repository) Object(path string, f *File) (object api.Object) {
+	object.Key = path
+	if f == nil {
+		return
+	}
+	c, ok := r.chains[f.chain]
+	if !ok {
+		return
+	}
+	for _, id := range c.blocks {
+		object.Blocks = append(object.Blocks, r.blocks[id].Block)
+	}
+	object.Version, object.Chain, object.Created = f.version, f.chain, f.created
+	object.Type, object.Metadata, object.Modified = c.mime, maps.Clone(c.metadata), c.created
+	object.Complete()
+	return
+}
+
+func (r *Repository) Lookup(_ context.Context, algorithm api.Algorithm, hash api.Hash, size int64) (blocks []api.Block, err error) {
+	r.Lock()
+	defer r.Unlock()
+	var rows []*Row
+	for _, b := range r.blocks {
+		if b.Algorithm == algorithm && b.Size == size && bytes.Equal(b.Hash, hash) && b.Kind != api.KindInline && !b.corrupt {
+			rows = append(rows, b)
+		}
+	}
+	slices.SortFunc(rows, func(a, b *Row) int {
+		switch {
+		case a.refer != b.refer:
+			return cmp.Compare(b.refer, a.refer)
+		case !a.updated.Equal(b.updated):
+			return a.updated.Compare(b.updated)
+		default:
+			return bytes.Compare(a.ID[:], b.ID[:])
+		}
+	})
+	for _, b := range rows {
+		blocks = append(blocks, api.Block{
+			ID:     b.ID,
+			Codec:  b.Codec,
+			Kind:   b.Kind,
+			Pack:   b.Pack,
+			Offset: b.Offset,
+			Length: b.Length,
+			Tier:   b.Tier,
+		})
+	}
+	return
+}
+
+func (r *Repository) Link(_ context.Context, cid uuid.UUID, bid1 uuid.UUID, bid2 uuid.UUID) error {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.blocks[bid2]
+	if !ok {
+		return ErrNotFound
+	}
+	c, ok := r.chains[cid]
+	if !ok {
+		return ErrNotFound
+	}
+	i := slices.Index(c.blocks, bid1)
+	if i < 0 {
+		return ErrNotFound
+	}
+	b.refer++
+	for ; i < len(c.blocks); i++ {
+		if c.blocks[i] == bid1 {
+			c.blocks[i] = bid2
+		}
+	}
+	if o, ok := r.blocks[bid1]; ok {
+		o.refer--
+		if o.refer == 0 {
+			r.Forget(o)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) Break(_ context.Context, cid uuid.UUID) ([]uuid.UUID, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.Drop(cid), nil
+}
+
+// Drop removes a chain and returns the storage blocks nobody refers to anymore.
+func (r *Repository) Drop(cid uuid.UUID) (blocks []uuid.UUID) {
+	c, ok := r.chains[cid]
+	if !ok {
+		return
+	}
+	delete(r.chains, cid)
+	for _, id := range c.blocks {
+		b, ok := r.blocks[id]
+		if !ok {
+			continue
+		}
+		b.refer--
+		if b.refer > 0 {
+			continue
+		}
+		r.Forget(b)
+		if b.Kind == api.KindStorage {
+			blocks = append(blocks, id)
+		}
+	}
+	return
+}
+
+func (r *Repository) Forget(b *Row) {
+	delete(r.blocks, b.ID)
+	if b.Kind != api.KindPacked {
+		return
+	}
+	if p, ok := r.packs[b.Pack]; ok {
+		p.Live -= b.Length
+	}
+}
+
+func (r *Repository) Update(_ context.Context, object api.Object, match uuid.UUID, linked []bool) ([]uuid.UUID, error) {
+	r.Lock()
+	defer r.Unlock()
+	path, upload, blocks := object.Key, object.Chain, object.Blocks
+	var chain uuid.UUID
+	f, ok := r.files[path]
+	if ok {
+		chain = f.chain
+	}
+	if !api.Match(chain, match) {
+		return nil, api.ErrPrecondition
+	}
+	if _, ok := r.chains[upload]; ok {
+		return nil, ErrExist
+	}
+	for i, b := range blocks {
+		if _, ok := r.blocks[b.ID]; linked[i] && !ok || !linked[i] && ok {
+			return nil, ErrNotFound
+		}
+	}
+	now := time.Now()
+	c := &Chain{mime: object.Type, metadata: maps.Clone(object.Metadata), created: now}
+	for i, b := range blocks {
+		c.blocks = append(c.blocks, b.ID)
+		if linked[i] {
+			r.blocks[b.ID].refer++
+			continue
+		}
+		if b.Kind == api.KindPacked {
+			p, ok := r.packs[b.Pack]
+			if !ok {
+				p = &api.Pack{ID: b.Pack}
+				r.packs[b.Pack] = p
+			}
+			p.Size += b.Length
+			p.Live += b.Length
+		} else {
+			b.Pack, b.Offset, b.Length = uuid.Nil, 0, 0
+		}
+		r.blocks[b.ID] = &Row{Block: b, refer: 1, updated: now, accessed: now}
+	}
+	r.chains[upload] = c
+	chains := []uuid.UUID{upload}
+	if ok {
+		chains = append(chains, f.chain)
+		f.version++
+	} else {
+		f = &File{id: uuid.New(), path: path, created: now}
+		r.files[path] = f
+	}
+	f.chain = upload
+	return chains, nil
+}
+
+func (r *Repository) Scan(_ context.Context, after uuid.UUID, limit int) (blocks []api.Block, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for id, b := range r.blocks {
+		if bytes.Compare(id[:], after[:]) > 0 {
+			blocks = append(blocks, b.Block)
+		}
+	}
+	slices.SortFunc(blocks, func(a, b api.Block) int {
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	return blocks[:min(limit, len(blocks))], nil
+}
+
+func (r *Repository) Rehash(_ context.Context, bid uuid.UUID, algorithm api.Algorithm, hash api.Hash) error {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.blocks[bid]
+	if !ok {
+		return ErrNotFound
+	}
+	b.Algorithm, b.Hash = algorithm, hash
+	return nil
+}
+
+func (r *Repository) Delete(_ context.Context, path string) ([]uuid.UUID, bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	f, ok := r.files[path]
+	if !ok {
+		return nil, false, nil
+	}
+	delete(r.files, path)
+	return r.Drop(f.chain), true, nil
+}
+
+func (r *Repository) Rename(_ context.Context, from string, to string, prefix bool, overwrite bool) (blocks []uuid.UUID, replaced bool, err error) {
+	r.Lock()
+	defer r.Unlock()
+	var names []string
+	for path, f := range r.files {
+		if f.chain != uuid.Nil && (!prefix && path == from || prefix && strings.HasPrefix(path, from)) {
+			names = append(names, path)
+		}
+	}
+	if len(names) == 0 {
+		return nil, false, api.ErrNotExist
+	}
+	slices.Sort(names)
+	for _, path := range names {
+		if _, ok := r.files[to+path[len(from):]]; ok && !overwrite {
+			return nil, false, api.ErrExist
+		}
+	}
+	for _, path := range names {
+		target := to + path[len(from):]
+		if t, ok := r.files[target]; ok {
+			blocks = append(blocks, r.Drop(t.chain)...)
+			replaced = true
+		}
+		f := r.files[path]
+		delete(r.files, path)
+		f.path = target
+		r.files[target] = f
+	}
+	return
+}
+
+func (r *Repository) Snapshot(_ context.Context, name string, prefix string) (api.Snapshot, error) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.snapshots[name]; ok {
+		return api.Snapshot{}, api.ErrExist
+	}
+	t := &Snapshot{prefix: prefix, created: time.Now(), files: map[string]*File{}}
+	for path, f := range r.files {
+		if _, ok := r.chains[f.chain]; ok && strings.HasPrefix(path, prefix) {
+			t.files[path] = &File{path: path, chain: r.Copy(f.chain), version: f.version, created: f.created}
+		}
+	}
+	if len(t.files) == 0 {
+		return api.Snapshot{}, api.ErrNotExist
+	}
+	r.snapshots[name] = t
+	return api.Snapshot{Name: name, Prefix: prefix, Files: len(t.files), Created: t.created}, nil
+}
+
+// Copy makes a new chain of the same blocks, so that they outlive the original one.
+func (r *Repository) Copy(cid uuid.UUID) uuid.UUID {
+	c := r.chains[cid]
+	id := uuid.New()
+	r.chains[id] = &Chain{blocks: slices.Clone(c.blocks), mime: c.mime, metadata: maps.Clone(c.metadata), created: c.created}
+	for _, b := range c.blocks {
+		if b, ok := r.blocks[b]; ok {
+			b.refer++
+		}
+	}
+	return id
+}
+
+func (r *Repository) Snapshots(_ context.Context) (snapshots []api.Snapshot, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for name, t := range r.snapshots {
+		snapshots = append(snapshots, api.Snapshot{Name: name, Prefix: t.prefix, Files: len(t.files), Created: t.created})
+	}
+	slices.SortFunc(snapshots, func(a, b api.Snapshot) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return
+}
+
+func (r *Repository) View(_ context.Context, name string, path string) (api.Object, error) {
+	r.Lock()
+	defer r.Unlock()
+	t, ok := r.snapshots[name]
+	if !ok {
+		return api.Object{}, api.ErrNotExist
+	}
+	return r.Object(path, t.files[path]), nil
+}
+
+func (r *Repository) Restore(_ context.Context, name string) (blocks []uuid.UUID, err error) {
+	r.Lock()
+	defer r.Unlock()
+	t, ok := r.snapshots[name]
+	if !ok {
+		return nil, api.ErrNotExist
+	}
+	for path, s := range t.files {
+		cid := r.Copy(s.chain)
+		if f, ok := r.files[path]; ok {
+			blocks = append(blocks, r.Drop(f.chain)...)
+			f.chain = cid
+			f.version++
+		} else {
+			r.files[path] = &File{id: uuid.New(), path: path, chain: cid, version: s.version, created: s.created}
+		}
+	}
+	return
+}
+
+func (r *Repository) Release(_ context.Context, name string) (blocks []uuid.UUID, err error) {
+	r.Lock()
+	defer r.Unlock()
+	t, ok := r.snapshots[name]
+	if !ok {
+		return nil, api.ErrNotExist
+	}
+	delete(r.snapshots, name)
+	for _, f := range t.files {
+		blocks = append(blocks, r.Drop(f.chain)...)
+	}
+	return
+}
+
+func (r *Repository) Sparse(_ context.Context, ratio float64, limit int) (packs []api.Pack, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, p := range r.packs {
+		if float64(p.Live) < float64(p.Size)*ratio {
+			packs = append(packs, *p)
+		}
+	}
+	slices.SortFunc(packs, func(a, b api.Pack) int {
+		if a.Live != b.Live {
+			return cmp.Compare(a.Live, b.Live)
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	return packs[:min(limit, len(packs))], nil
+}
+
+func (r *Repository) Unpack(_ context.Context, pid uuid.UUID) (blocks []api.Block, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, b := range r.blocks {
+		if b.Kind == api.KindPacked && b.Pack == pid {
+			blocks = append(blocks, b.Block)
+		}
+	}
+	slices.SortFunc(blocks, func(a, b api.Block) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	return
+}
+
+func (r *Repository) Repack(_ context.Context, pid1 uuid.UUID, pid2 uuid.UUID, blocks []uuid.UUID, offsets []int64) error {
+	r.Lock()
+	defer r.Unlock()
+	var total int64
+	for i, id := range blocks {
+		b, ok := r.blocks[id]
+		if !ok || b.Kind != api.KindPacked || b.Pack != pid1 {
+			continue
+		}
+		b.Pack, b.Offset = pid2, offsets[i]
+		total += b.Length
+	}
+	if total > 0 {
+		r.packs[pid2] = &api.Pack{ID: pid2, Size: total, Live: total}
+	}
+	for _, b := range r.blocks {
+		if b.Kind == api.KindPacked && b.Pack == pid1 {
+			return errors.New("pack in use")
+		}
+	}
+	delete(r.packs, pid1)
+	return nil
+}
+
+func (r *Repository) Exist(_ context.Context, ids []uuid.UUID) (known []uuid.UUID, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, id := range ids {
+		if b, ok := r.blocks[id]; ok && b.Kind == api.KindStorage {
+			known = append(known, id)
+		} else if _, ok = r.packs[id]; ok {
+			known = append(known, id)
+		}
+	}
+	return
+}
+
+func (r *Repository) Check(_ context.Context, bid uuid.UUID, corrupt bool) error {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.blocks[bid]
+	if ok {
+		b.checked, b.corrupt = time.Now(), corrupt
+	}
+	return nil
+}
+
+func (r *Repository) Affected(_ context.Context, bid uuid.UUID) (names []string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	for path, f := range r.files {
+		if c, ok := r.chains[f.chain]; ok && slices.Contains(c.blocks, bid) {
+			names = append(names, path)
+		}
+	}
+	slices.Sort(names)
+	return
+}
+
+func (r *Repository) Touch(_ context.Context, blocks []uuid.UUID) error {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	for _, id := range blocks {
+		if b, ok := r.blocks[id]; ok {
+			b.accessed = now
+		}
+	}
+	return nil
+}
+
+func (r *Repository) Stale(_ context.Context, before time.Time, tier api.Tier, limit int) (blocks []api.Block, err error) {
+	r.Lock()
+	defer r.Unlock()
+	var rows []*Row
+	for _, b := range r.blocks {
+		if b.accessed.Before(before) && b.Tier == tier && b.Kind == api.KindStorage {
+			rows = append(rows, b)
+		}
+	}
+	slices.SortFunc(rows, func(a, b *Row) int {
+		return a.accessed.Compare(b.accessed)
+	})
+	for _, b := range rows[:min(limit, len(rows))] {
+		blocks = append(blocks, b.Block)
+	}
+	return
+}
+
+func (r *Repository) Move(_ context.Context, bid uuid.UUID, from, to api.Tier) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.blocks[bid]
+	if !ok || b.Tier != from {
+		return false, nil
+	}
+	b.Tier = to
+	return true, nil
+}
+
+func (r *Repository) Shutdown() {}
+
+var repositories sync.Map
+
+func New(u *url.URL) (*Repository, error) {
+	r, _ := repositories.LoadOrStore(u.Host, &Repository{
+		files:     map[string]*File{},
+		chains:    map[uuid.UUID]*Chain{},
+		blocks:    map[uuid.UUID]*Row{},
+		packs:     map[uuid.UUID]*api.Pack{},
+		snapshots: map[string]*Snapshot{},
+	})
+	return r.(*Repository), nil
+}
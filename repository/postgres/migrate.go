@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// lock is the key of the advisory lock held while migrating, so that concurrently started services apply the schema once.
+const lock = 0x6e6f636f7079
+
+var ErrBaseline = errors.New("database schema does not match the first migration")
+
+// baseline holds the columns of the tables made by the pg_dump the first migration reproduces.
+var baseline = map[string][]string{
+	"blocks": {"hash", "id", "refer", "size", "updated"},
+	"chains": {"created", "id", "mime"},
+	"files":  {"chain_id", "id", "path", "version"},
+	"links":  {"block_id", "chain_id", "ordinal"},
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// Migrations returns the embedded migrations ordered by version, the version is the numeric prefix of the file name.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []Migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration %q", e.Name())
+		}
+		b, err := migrations.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: e.Name(), Query: string(b)})
+	}
+	slices.SortFunc(list, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", list[i].Version)
+		}
+	}
+	return list, nil
+}
+
+// Migrate applies every migration newer than the recorded schema version, each one in its own transaction.
+// A database created from the former pg_dump schema is recorded as version 1 without applying it, as long as its tables
+// match that dump.
+func Migrate(ctx context.Context, db *sqlx.DB) (version int, err error) {
+	list, err := Migrations()
+	if err != nil {
+		return
+	}
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.ExecContext(ctx, "select pg_advisory_lock($1)", lock)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lock)
+	}()
+	_, err = conn.ExecContext(ctx, `create table if not exists migrations (
+    version integer primary key,
+    name    text not null,
+    applied timestamp with time zone default now() not null
+)`)
+	if err != nil {
+		return
+	}
+	err = conn.GetContext(ctx, &version, "select coalesce(max(version), 0) from migrations")
+	if err != nil {
+		return
+	}
+	if version == 0 && len(list) > 0 {
+		var legacy bool
+		err = conn.GetContext(ctx, &legacy, "select to_regclass('public.files') is not null")
+		if err != nil {
+			return
+		}
+		if legacy {
+			err = Baseline(ctx, conn)
+			if err != nil {
+				return
+			}
+			_, err = conn.ExecContext(ctx, "insert into migrations (version, name) values ($1, $2)", list[0].Version, list[0].Name)
+			if err != nil {
+				return
+			}
+			version = list[0].Version
+			slog.Info("migrate", "version", version, "name", list[0].Name, "baseline", true)
+		}
+	}
+	for _, m := range list {
+		if m.Version <= version {
+			continue
+		}
+		err = apply(ctx, conn, m)
+		if err != nil {
+			return version, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		version = m.Version
+		slog.Info("migrate", "version", version, "name", m.Name)
+	}
+	return
+}
+
+func Baseline(ctx context.Context, conn *sqlx.Conn) error {
+	var columns []struct {
+		Table  string `db:"table_name"`
+		Column string `db:"column_name"`
+	}
+	err := conn.SelectContext(ctx, &columns, `select table_name, column_name from information_schema.columns
+		where table_schema = 'public' and table_name <> 'migrations'
+		order by table_name, column_name`)
+	if err != nil {
+		return err
+	}
+	tables := map[string][]string{}
+	for _, c := range columns {
+		tables[c.Table] = append(tables[c.Table], c.Column)
+	}
+	if !maps.EqualFunc(tables, baseline, slices.Equal[[]string]) {
+		return ErrBaseline
+	}
+	return nil
+}
+
+func apply(ctx context.Context, conn *sqlx.Conn, m Migration) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, m.Query)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "insert into migrations (version, name) values ($1, $2)", m.Version, m.Name)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
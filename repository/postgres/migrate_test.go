@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+func TestMigrations(t *testing.T) {
+	list, err := Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+	for i, m := range list {
+		require.Equal(t, i+1, m.Version)
+		require.NotContains(t, m.Query, "CREATE DATABASE")
+		require.NotContains(t, m.Query, "OWNER TO")
+	}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES is not set")
+	}
+	ctx := context.TODO()
+	admin, err := sqlx.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer admin.Close()
+	name := "nocopy_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.ExecContext(ctx, "create database "+name)
+	require.NoError(t, err)
+	defer func() {
+		_, _ = admin.ExecContext(context.Background(), "drop database "+name+" with (force)")
+	}()
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+	u.Path = "/" + name
+
+	dump, err := os.ReadFile("testdata/baseline.sql")
+	require.NoError(t, err)
+	db, err := sqlx.Open("pgx", u.String())
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, string(dump))
+	require.NoError(t, err)
+	block, chain := uuid.New(), uuid.New()
+	_, err = db.ExecContext(ctx, "insert into public.blocks (id, hash, size) values ($1, $2, 3)", block, []byte{1})
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "insert into public.chains (id) values ($1)", chain)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "insert into public.links (chain_id, block_id, ordinal) values ($1, $2, 0)", chain, block)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "insert into public.files (path, chain_id) values ('/a', $1)", chain)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	r, err := New(u)
+	require.NoError(t, err)
+	defer r.Shutdown()
+	list, err := Migrations()
+	require.NoError(t, err)
+	version, err := Migrate(ctx, r.db)
+	require.NoError(t, err)
+	require.Equal(t, len(list), version)
+	object, err := r.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, chain, object.Chain)
+	require.Len(t, object.Blocks, 1)
+	require.Equal(t, block, object.Blocks[0].ID)
+	chains, err := r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{{ID: block}}}, api.Any, []bool{true})
+	require.NoError(t, err)
+	require.Equal(t, chain, chains[1])
+
+	_, err = r.db.ExecContext(ctx, "drop table migrations")
+	require.NoError(t, err)
+	_, err = Migrate(ctx, r.db)
+	require.ErrorIs(t, err, ErrBaseline)
+}
@@ -1,46 +1,4 @@
---
--- PostgreSQL database dump
---
-
--- Dumped from database version 13.9
--- Dumped by pg_dump version 13.7
-
-SET statement_timeout = 0;
-SET lock_timeout = 0;
-SET idle_in_transaction_session_timeout = 0;
-SET client_encoding = 'UTF8';
-SET standard_conforming_strings = on;
-SELECT pg_catalog.set_config('search_path', '', false);
-SET check_function_bodies = false;
-SET xmloption = content;
-SET client_min_messages = warning;
-SET row_security = off;
-
---
--- Name: nocopy; Type: DATABASE; Schema: -; Owner: postgres
---
-
-CREATE DATABASE nocopy WITH TEMPLATE = template0 ENCODING = 'UTF8' LC_COLLATE = 'C' LC_CTYPE = 'ru_RU.UTF-8';
-
-
-ALTER DATABASE nocopy OWNER TO postgres;
-
-\connect nocopy
-
-SET statement_timeout = 0;
-SET lock_timeout = 0;
-SET idle_in_transaction_session_timeout = 0;
-SET client_encoding = 'UTF8';
-SET standard_conforming_strings = on;
-SELECT pg_catalog.set_config('search_path', '', false);
-SET check_function_bodies = false;
-SET xmloption = content;
-SET client_min_messages = warning;
-SET row_security = off;
-
---
--- Name: block_delete(uuid); Type: FUNCTION; Schema: public; Owner: postgres
---
+set local check_function_bodies = false;
 
 CREATE FUNCTION public.block_delete(v_chain_id uuid) RETURNS TABLE(block_id uuid)
     LANGUAGE plpgsql
@@ -62,17 +20,10 @@ begin
         end loop;
     delete from chains where id = v_chain_id;
     return query
-        select * from unnest(v_block_ids);
+        select * from unnest(v_block_ids) as u where u <> null_uuid();
 end
 $$;
 
-
-ALTER FUNCTION public.block_delete(v_chain_id uuid) OWNER TO postgres;
-
---
--- Name: block_insert(uuid, uuid[], bytea[], bigint[]); Type: FUNCTION; Schema: public; Owner: postgres
---
-
 CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[]) RETURNS TABLE(chain_id uuid)
     LANGUAGE plpgsql
     AS $$
@@ -95,17 +46,10 @@ begin
     end if;
     update files set chain_id = n_chain_id where files.id = v_file_id;
     return query
-        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]);
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
 end
 $$;
 
-
-ALTER FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[]) OWNER TO postgres;
-
---
--- Name: block_select(bytea, bigint); Type: FUNCTION; Schema: public; Owner: postgres
---
-
 CREATE FUNCTION public.block_select(v_hash bytea, v_size bigint) RETURNS TABLE(block_id uuid)
     LANGUAGE plpgsql
     AS $$
@@ -116,14 +60,7 @@ begin
 end
 $$;
 
-
-ALTER FUNCTION public.block_select(v_hash bytea, v_size bigint) OWNER TO postgres;
-
---
--- Name: block_update(uuid, uuid, uuid); Type: PROCEDURE; Schema: public; Owner: postgres
---
-
-CREATE PROCEDURE public.block_update(v_chain_id uuid, o_block_id uuid, n_block_id uuid)
+CREATE PROCEDURE public.block_update(IN v_chain_id uuid, IN o_block_id uuid, IN n_block_id uuid)
     LANGUAGE plpgsql
     AS $$
 declare
@@ -140,13 +77,6 @@ begin
 end
 $$;
 
-
-ALTER PROCEDURE public.block_update(v_chain_id uuid, o_block_id uuid, n_block_id uuid) OWNER TO postgres;
-
---
--- Name: file_delete(text); Type: FUNCTION; Schema: public; Owner: postgres
---
-
 CREATE FUNCTION public.file_delete(v_path text) RETURNS TABLE(block_id uuid)
     LANGUAGE plpgsql
     AS $$
@@ -162,13 +92,6 @@ begin
 end
 $$;
 
-
-ALTER FUNCTION public.file_delete(v_path text) OWNER TO postgres;
-
---
--- Name: file_insert(text); Type: FUNCTION; Schema: public; Owner: postgres
---
-
 CREATE FUNCTION public.file_insert(v_path text) RETURNS uuid
     LANGUAGE plpgsql
     AS $$
@@ -180,13 +103,6 @@ begin
 end
 $$;
 
-
-ALTER FUNCTION public.file_insert(v_path text) OWNER TO postgres;
-
---
--- Name: file_select(text); Type: FUNCTION; Schema: public; Owner: postgres
---
-
 CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, mime text, created timestamp with time zone)
     LANGUAGE plpgsql
     AS $$
@@ -203,16 +119,11 @@ begin
 end
 $$;
 
-
-ALTER FUNCTION public.file_select(v_path text) OWNER TO postgres;
-
-SET default_tablespace = '';
-
-SET default_table_access_method = heap;
-
---
--- Name: blocks; Type: TABLE; Schema: public; Owner: postgres
---
+CREATE FUNCTION public.null_uuid() RETURNS uuid
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT '00000000-0000-0000-0000-000000000000'::uuid;
+$$;
 
 CREATE TABLE public.blocks (
     id uuid DEFAULT gen_random_uuid() NOT NULL,
@@ -222,26 +133,12 @@ CREATE TABLE public.blocks (
     updated timestamp with time zone DEFAULT now() NOT NULL
 );
 
-
-ALTER TABLE public.blocks OWNER TO postgres;
-
---
--- Name: chains; Type: TABLE; Schema: public; Owner: postgres
---
-
 CREATE TABLE public.chains (
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     mime text DEFAULT ''::text NOT NULL,
     created timestamp with time zone DEFAULT now() NOT NULL
 );
 
-
-ALTER TABLE public.chains OWNER TO postgres;
-
---
--- Name: files; Type: TABLE; Schema: public; Owner: postgres
---
-
 CREATE TABLE public.files (
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     path text NOT NULL,
@@ -249,94 +146,42 @@ CREATE TABLE public.files (
     version integer DEFAULT 0 NOT NULL
 );
 
-
-ALTER TABLE public.files OWNER TO postgres;
-
---
--- Name: links; Type: TABLE; Schema: public; Owner: postgres
---
-
 CREATE TABLE public.links (
     chain_id uuid NOT NULL,
     block_id uuid NOT NULL,
     ordinal integer NOT NULL
 );
 
-
-ALTER TABLE public.links OWNER TO postgres;
-
---
--- Name: blocks blocks_pk; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.blocks
     ADD CONSTRAINT blocks_pk PRIMARY KEY (id);
 
-
---
--- Name: chains chains_pk; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.chains
     ADD CONSTRAINT chains_pk PRIMARY KEY (id);
 
-
---
--- Name: files files_path_idx; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.files
     ADD CONSTRAINT files_path_idx UNIQUE (path);
 
-
---
--- Name: files files_pk; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.files
     ADD CONSTRAINT files_pk PRIMARY KEY (id);
 
-
---
--- Name: links links_chain_id_ordinal_idx; Type: CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.links
     ADD CONSTRAINT links_chain_id_ordinal_idx UNIQUE (chain_id, ordinal);
 
+CREATE INDEX blocks_hash_size_idx ON public.blocks USING btree (hash, size);
 
---
--- Name: blocks_hash_idx; Type: INDEX; Schema: public; Owner: postgres
---
+CREATE UNIQUE INDEX files_chain_id_idx ON public.files USING btree (chain_id);
 
-CREATE INDEX blocks_hash_idx ON public.blocks USING btree (hash);
+CREATE INDEX files_path_chain_id_idx ON public.files USING btree (path, chain_id);
 
+CREATE INDEX links_block_id_idx ON public.links USING hash (block_id);
 
---
--- Name: files files_chain_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
---
+CREATE INDEX links_chain_id_block_id_ordinal_idx ON public.links USING btree (chain_id, block_id, ordinal);
 
 ALTER TABLE ONLY public.files
     ADD CONSTRAINT files_chain_id_fk FOREIGN KEY (chain_id) REFERENCES public.chains(id);
 
-
---
--- Name: links links_block_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.links
     ADD CONSTRAINT links_block_id_fk FOREIGN KEY (block_id) REFERENCES public.blocks(id);
 
-
---
--- Name: links links_chain_id_fk; Type: FK CONSTRAINT; Schema: public; Owner: postgres
---
-
 ALTER TABLE ONLY public.links
     ADD CONSTRAINT links_chain_id_fk FOREIGN KEY (chain_id) REFERENCES public.chains(id);
-
-
---
--- PostgreSQL database dump complete
---
-
@@ -0,0 +1,59 @@
+ALTER TABLE public.blocks ADD COLUMN codec smallint DEFAULT 0 NOT NULL;
+
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[]);
+
+DROP FUNCTION public.block_select(bytea, bigint);
+
+DROP FUNCTION public.file_select(text);
+
+CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains select returning id into n_chain_id;
+    foreach v_block_id in array v_block_ids
+        loop
+            insert into blocks (id, hash, size, codec) values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1]);
+            insert into links (chain_id, block_id, ordinal) values (n_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.id = v_file_id for update into o_chain_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update files set chain_id = n_chain_id where files.id = v_file_id;
+    return query
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.block_select(v_hash bytea, v_size bigint) RETURNS TABLE(block_id uuid, codec smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select id, blocks.codec from blocks where hash = v_hash and size = v_size order by refer desc, updated, id;
+end
+$$;
+
+CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, codec smallint, mime text, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select links.block_id, blocks.size, blocks.codec, chains.mime, chains.created
+        from files
+                 join chains on chains.id = files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where files.path = v_path 
+        order by links.ordinal;
+end
+$$;
@@ -0,0 +1,67 @@
+ALTER TABLE public.blocks ADD COLUMN algorithm smallint DEFAULT 0 NOT NULL;
+
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[], smallint[]);
+
+DROP FUNCTION public.block_select(bytea, bigint);
+
+CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithm smallint) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains select returning id into n_chain_id;
+    foreach v_block_id in array v_block_ids
+        loop
+            insert into blocks (id, hash, size, codec, algorithm) values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithm);
+            insert into links (chain_id, block_id, ordinal) values (n_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.id = v_file_id for update into o_chain_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update files set chain_id = n_chain_id where files.id = v_file_id;
+    return query
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
+
+CREATE PROCEDURE public.block_rehash(IN v_block_id uuid, IN v_algorithm smallint, IN v_hash bytea)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set hash = v_hash, algorithm = v_algorithm where id = v_block_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+end
+$$;
+
+CREATE FUNCTION public.block_scan(v_after uuid, v_limit integer) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm
+        from blocks
+        where blocks.id > v_after
+        order by blocks.id
+        limit v_limit;
+end
+$$;
+
+CREATE FUNCTION public.block_select(v_hash bytea, v_size bigint, v_algorithm smallint) RETURNS TABLE(block_id uuid, codec smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select id, blocks.codec from blocks where hash = v_hash and size = v_size and algorithm = v_algorithm order by refer desc, updated, id;
+end
+$$;
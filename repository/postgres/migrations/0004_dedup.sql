@@ -0,0 +1,34 @@
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[], smallint[], smallint);
+
+CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithm smallint, v_linked boolean[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains select returning id into n_chain_id;
+    foreach v_block_id in array v_block_ids
+        loop
+            if v_linked[i + 1] then
+                update blocks set refer = refer + 1 where id = v_block_id;
+                if not found then
+                    raise exception 'not found';
+                end if;
+            else
+                insert into blocks (id, hash, size, codec, algorithm) values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithm);
+            end if;
+            insert into links (chain_id, block_id, ordinal) values (n_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.id = v_file_id for update into o_chain_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update files set chain_id = n_chain_id where files.id = v_file_id;
+    return query
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
@@ -0,0 +1,125 @@
+ALTER TABLE public.blocks ADD COLUMN kind smallint DEFAULT 0 NOT NULL;
+
+ALTER TABLE public.blocks ADD COLUMN payload bytea;
+
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[], smallint[], smallint, boolean[]);
+
+DROP FUNCTION public.block_scan(uuid, integer);
+
+DROP FUNCTION public.file_select(text);
+
+CREATE OR REPLACE FUNCTION public.block_delete(v_chain_id uuid) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_refer     int;
+    v_kind      smallint;
+    v_block_id  uuid;
+    v_block_ids uuid[] = array []::uuid[];
+begin
+    for v_block_id in delete from links where chain_id = v_chain_id returning links.block_id
+        loop
+            update blocks set refer = blocks.refer - 1 where id = v_block_id returning blocks.refer, blocks.kind into v_refer, v_kind;
+            if v_refer = 0 then
+                delete from blocks where id = v_block_id and refer = 0;
+            end if;
+            if v_refer <> 0 or v_kind <> 0 then
+                v_block_id := null;
+            end if;
+            v_block_ids := v_block_ids || v_block_id;
+        end loop;
+    delete from chains where id = v_chain_id;
+    return query
+        select * from unnest(v_block_ids) as u where u <> null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithm smallint, v_linked boolean[], v_payloads bytea[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains select returning id into n_chain_id;
+    foreach v_block_id in array v_block_ids
+        loop
+            if v_linked[i + 1] then
+                update blocks set refer = refer + 1 where id = v_block_id;
+                if not found then
+                    raise exception 'not found';
+                end if;
+            else
+                insert into blocks (id, hash, size, codec, algorithm, kind, payload)
+                values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithm,
+                        case when v_payloads[i + 1] is null then 0 else 1 end, v_payloads[i + 1]);
+            end if;
+            insert into links (chain_id, block_id, ordinal) values (n_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.id = v_file_id for update into o_chain_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update files set chain_id = n_chain_id where files.id = v_file_id;
+    return query
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.block_scan(v_after uuid, v_limit integer) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload
+        from blocks
+        where blocks.id > v_after
+        order by blocks.id
+        limit v_limit;
+end
+$$;
+
+CREATE OR REPLACE FUNCTION public.block_select(v_hash bytea, v_size bigint, v_algorithm smallint) RETURNS TABLE(block_id uuid, codec smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select id, blocks.codec from blocks where hash = v_hash and size = v_size and algorithm = v_algorithm and kind = 0 order by refer desc, updated, id;
+end
+$$;
+
+CREATE OR REPLACE FUNCTION public.file_delete(v_path text) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_chain_id uuid;
+begin
+    delete from files where path = v_path returning files.chain_id into v_chain_id;
+    if not found then
+        return;
+    end if;
+    return query
+        select * from block_delete(v_chain_id) union all select null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, codec smallint, kind smallint, payload bytea, mime text, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select links.block_id, blocks.size, blocks.codec, blocks.kind, blocks.payload, chains.mime, chains.created
+        from files
+                 join chains on chains.id = files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where files.path = v_path 
+        order by links.ordinal;
+end
+$$;
@@ -0,0 +1,182 @@
+ALTER TABLE public.blocks ADD COLUMN pack_id uuid;
+
+ALTER TABLE public.blocks ADD COLUMN pack_offset bigint DEFAULT 0 NOT NULL;
+
+ALTER TABLE public.blocks ADD COLUMN pack_length bigint DEFAULT 0 NOT NULL;
+
+CREATE TABLE public.packs (
+    id uuid NOT NULL,
+    size bigint DEFAULT 0 NOT NULL,
+    live bigint DEFAULT 0 NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[], smallint[], smallint, boolean[], bytea[]);
+
+DROP FUNCTION public.block_scan(uuid, integer);
+
+DROP FUNCTION public.block_select(bytea, bigint, smallint);
+
+DROP FUNCTION public.file_select(text);
+
+CREATE FUNCTION public.block_insert(v_file_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithms smallint[], v_kinds smallint[], v_payloads bytea[], v_packs uuid[], v_offsets bigint[], v_lengths bigint[], v_linked boolean[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains select returning id into n_chain_id;
+    foreach v_block_id in array v_block_ids
+        loop
+            if v_linked[i + 1] then
+                update blocks set refer = refer + 1 where id = v_block_id;
+                if not found then
+                    raise exception 'not found';
+                end if;
+            else
+                if v_kinds[i + 1] = 2 then
+                    insert into packs (id, size, live) values (v_packs[i + 1], v_lengths[i + 1], v_lengths[i + 1])
+                    on conflict (id) do update set size = packs.size + excluded.size, live = packs.live + excluded.live;
+                end if;
+                insert into blocks (id, hash, size, codec, algorithm, kind, payload, pack_id, pack_offset, pack_length)
+                values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithms[i + 1], v_kinds[i + 1], v_payloads[i + 1],
+                        case when v_kinds[i + 1] = 2 then v_packs[i + 1] end, v_offsets[i + 1], v_lengths[i + 1]);
+            end if;
+            insert into links (chain_id, block_id, ordinal) values (n_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.id = v_file_id for update into o_chain_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update files set chain_id = n_chain_id where files.id = v_file_id;
+    return query
+        select * from unnest(array [n_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.block_scan(v_after uuid, v_limit integer) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length
+        from blocks
+        where blocks.id > v_after
+        order by blocks.id
+        limit v_limit;
+end
+$$;
+
+CREATE FUNCTION public.block_select(v_hash bytea, v_size bigint, v_algorithm smallint) RETURNS TABLE(id uuid, codec smallint, kind smallint, pack_id uuid, pack_offset bigint, pack_length bigint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.codec, blocks.kind, coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length
+        from blocks
+        where hash = v_hash
+          and size = v_size
+          and algorithm = v_algorithm
+          and blocks.kind <> 1
+        order by refer desc, updated, id;
+end
+$$;
+
+CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, codec smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, mime text, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select links.block_id, blocks.size, blocks.codec, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, chains.mime, chains.created
+        from files
+                 join chains on chains.id = files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where files.path = v_path 
+        order by links.ordinal;
+end
+$$;
+
+CREATE FUNCTION public.pack_blocks(v_pack_id uuid) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               blocks.pack_id, blocks.pack_offset, blocks.pack_length
+        from blocks
+        where blocks.pack_id = v_pack_id
+        order by blocks.pack_offset;
+end
+$$;
+
+CREATE FUNCTION public.pack_forget() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update packs set live = live - old.pack_length where id = old.pack_id;
+    return old;
+end
+$$;
+
+CREATE FUNCTION public.pack_select(v_ratio double precision, v_limit integer) RETURNS TABLE(id uuid, size bigint, live bigint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select packs.id, packs.size, packs.live
+        from packs
+        where packs.live < packs.size * v_ratio
+        order by packs.live, packs.id
+        limit v_limit;
+end
+$$;
+
+CREATE PROCEDURE public.pack_update(IN v_pack_id uuid, IN n_pack_id uuid, IN v_block_ids uuid[], IN v_offsets bigint[])
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_length bigint;
+    v_total  bigint not null default 0;
+    i        int not null default 1;
+begin
+    perform from packs where id = v_pack_id for update;
+    while i <= coalesce(array_length(v_block_ids, 1), 0)
+        loop
+            update blocks
+            set pack_id = n_pack_id, pack_offset = v_offsets[i]
+            where id = v_block_ids[i] and pack_id = v_pack_id
+            returning pack_length into v_length;
+            if found then
+                v_total := v_total + v_length;
+            end if;
+            i := i + 1;
+        end loop;
+    if v_total > 0 then
+        insert into packs (id, size, live) values (n_pack_id, v_total, v_total);
+    end if;
+    if exists(select from blocks where pack_id = v_pack_id) then
+        raise exception 'pack in use';
+    end if;
+    delete from packs where id = v_pack_id;
+end
+$$;
+
+ALTER TABLE ONLY public.packs
+    ADD CONSTRAINT packs_pk PRIMARY KEY (id);
+
+CREATE INDEX blocks_pack_id_idx ON public.blocks USING btree (pack_id);
+
+CREATE TRIGGER blocks_pack_forget AFTER DELETE ON public.blocks FOR EACH ROW WHEN ((old.pack_id IS NOT NULL)) EXECUTE FUNCTION public.pack_forget();
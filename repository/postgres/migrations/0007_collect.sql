@@ -0,0 +1,11 @@
+CREATE FUNCTION public.object_select(v_ids uuid[]) RETURNS TABLE(id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id from blocks where blocks.id = any (v_ids) and blocks.kind = 0
+        union all
+        select packs.id from packs where packs.id = any (v_ids);
+end
+$$;
@@ -0,0 +1,43 @@
+ALTER TABLE public.blocks ADD COLUMN checked timestamp with time zone;
+
+ALTER TABLE public.blocks ADD COLUMN corrupt boolean DEFAULT false NOT NULL;
+
+CREATE PROCEDURE public.block_check(IN v_block_id uuid, IN v_corrupt boolean)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set checked = now(), corrupt = v_corrupt where id = v_block_id;
+end
+$$;
+
+CREATE OR REPLACE FUNCTION public.block_select(v_hash bytea, v_size bigint, v_algorithm smallint) RETURNS TABLE(id uuid, codec smallint, kind smallint, pack_id uuid, pack_offset bigint, pack_length bigint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.codec, blocks.kind, coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length
+        from blocks
+        where hash = v_hash
+          and size = v_size
+          and algorithm = v_algorithm
+          and blocks.kind <> 1
+          and not blocks.corrupt
+        order by refer desc, updated, id;
+end
+$$;
+
+CREATE FUNCTION public.file_affected(v_block_id uuid) RETURNS TABLE(path text)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select files.path
+        from links
+                 join files on files.chain_id = links.chain_id
+        where links.block_id = v_block_id
+        order by files.path;
+end
+$$;
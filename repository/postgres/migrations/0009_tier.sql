@@ -0,0 +1,114 @@
+ALTER TABLE public.blocks ADD COLUMN accessed timestamp with time zone DEFAULT now() NOT NULL;
+
+ALTER TABLE public.blocks ADD COLUMN tier smallint DEFAULT 0 NOT NULL;
+
+DROP FUNCTION public.block_scan(uuid, integer);
+
+DROP FUNCTION public.block_select(bytea, bigint, smallint);
+
+DROP FUNCTION public.file_select(text);
+
+DROP FUNCTION public.pack_blocks(uuid);
+
+CREATE PROCEDURE public.block_move(IN v_block_id uuid, IN v_tier smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set tier = v_tier where id = v_block_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+end
+$$;
+
+CREATE FUNCTION public.block_scan(v_after uuid, v_limit integer) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, blocks.tier
+        from blocks
+        where blocks.id > v_after
+        order by blocks.id
+        limit v_limit;
+end
+$$;
+
+CREATE FUNCTION public.block_select(v_hash bytea, v_size bigint, v_algorithm smallint) RETURNS TABLE(id uuid, codec smallint, kind smallint, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.codec, blocks.kind, coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, blocks.tier
+        from blocks
+        where hash = v_hash
+          and size = v_size
+          and algorithm = v_algorithm
+          and blocks.kind <> 1
+          and not blocks.corrupt
+        order by refer desc, updated, id;
+end
+$$;
+
+CREATE FUNCTION public.block_stale(v_before timestamp with time zone, v_tier smallint, v_limit integer) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               null_uuid(), blocks.pack_offset, blocks.pack_length, blocks.tier
+        from blocks
+        where blocks.accessed < v_before
+          and blocks.tier = v_tier
+          and blocks.kind = 0
+        order by blocks.accessed
+        limit v_limit;
+end
+$$;
+
+CREATE PROCEDURE public.block_touch(IN v_block_ids uuid[])
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set accessed = now() where id = any (v_block_ids);
+end
+$$;
+
+CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, codec smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint, mime text, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select links.block_id, blocks.size, blocks.codec, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, blocks.tier, chains.mime, chains.created
+        from files
+                 join chains on chains.id = files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where files.path = v_path 
+        order by links.ordinal;
+end
+$$;
+
+CREATE FUNCTION public.pack_blocks(v_pack_id uuid) RETURNS TABLE(id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select blocks.id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               blocks.pack_id, blocks.pack_offset, blocks.pack_length, blocks.tier
+        from blocks
+        where blocks.pack_id = v_pack_id
+        order by blocks.pack_offset;
+end
+$$;
+
+CREATE INDEX blocks_accessed_idx ON public.blocks USING btree (tier, accessed) WHERE (kind = 0);
@@ -0,0 +1,41 @@
+DROP FUNCTION public.block_insert(uuid, uuid[], bytea[], bigint[], smallint[], smallint[], smallint[], bytea[], uuid[], bigint[], bigint[], boolean[]);
+
+DROP FUNCTION public.file_insert(text);
+
+DELETE FROM public.files WHERE chain_id IS NULL;
+
+CREATE FUNCTION public.block_insert(v_path text, v_chain_id uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithms smallint[], v_kinds smallint[], v_payloads bytea[], v_packs uuid[], v_offsets bigint[], v_lengths bigint[], v_linked boolean[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into chains (id) values (v_chain_id);
+    foreach v_block_id in array v_block_ids
+        loop
+            if v_linked[i + 1] then
+                update blocks set refer = refer + 1 where id = v_block_id;
+                if not found then
+                    raise exception 'not found';
+                end if;
+            else
+                if v_kinds[i + 1] = 2 then
+                    insert into packs (id, size, live) values (v_packs[i + 1], v_lengths[i + 1], v_lengths[i + 1])
+                    on conflict (id) do update set size = packs.size + excluded.size, live = packs.live + excluded.live;
+                end if;
+                insert into blocks (id, hash, size, codec, algorithm, kind, payload, pack_id, pack_offset, pack_length)
+                values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithms[i + 1], v_kinds[i + 1], v_payloads[i + 1],
+                        case when v_kinds[i + 1] = 2 then v_packs[i + 1] end, v_offsets[i + 1], v_lengths[i + 1]);
+            end if;
+            insert into links (chain_id, block_id, ordinal) values (v_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    select files.chain_id from files where files.path = v_path for update into o_chain_id;
+    insert into files (path, chain_id) values (v_path, v_chain_id)
+    on conflict (path) do update set chain_id = excluded.chain_id, version = files.version + 1;
+    return query
+        select * from unnest(array [v_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
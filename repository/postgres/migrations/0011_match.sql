@@ -0,0 +1,67 @@
+DROP FUNCTION public.block_insert(text, uuid, uuid[], bytea[], bigint[], smallint[], smallint[], smallint[], bytea[], uuid[], bigint[], bigint[], boolean[]);
+
+DROP FUNCTION public.file_select(text);
+
+CREATE FUNCTION public.any_uuid() RETURNS uuid
+    LANGUAGE sql IMMUTABLE
+    AS $$
+    SELECT 'ffffffff-ffff-ffff-ffff-ffffffffffff'::uuid;
+$$;
+
+CREATE FUNCTION public.block_insert(v_path text, v_chain_id uuid, v_match uuid, v_block_ids uuid[], v_hashes bytea[], sizes bigint[], v_codecs smallint[], v_algorithms smallint[], v_kinds smallint[], v_payloads bytea[], v_packs uuid[], v_offsets bigint[], v_lengths bigint[], v_linked boolean[]) RETURNS TABLE(chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    o_chain_id uuid;
+    v_block_id uuid;
+    i          int not null default 0;
+begin
+    insert into files (path) values (v_path) on conflict (path) do nothing;
+    select files.chain_id from files where files.path = v_path for update into o_chain_id;
+    if v_match <> null_uuid() and (o_chain_id is null or v_match <> any_uuid() and v_match <> o_chain_id) then
+        raise exception 'precondition failed' using errcode = 'NC412';
+    end if;
+    insert into chains (id) values (v_chain_id);
+    foreach v_block_id in array v_block_ids
+        loop
+            if v_linked[i + 1] then
+                update blocks set refer = refer + 1 where id = v_block_id;
+                if not found then
+                    raise exception 'not found';
+                end if;
+            else
+                if v_kinds[i + 1] = 2 then
+                    insert into packs (id, size, live) values (v_packs[i + 1], v_lengths[i + 1], v_lengths[i + 1])
+                    on conflict (id) do update set size = packs.size + excluded.size, live = packs.live + excluded.live;
+                end if;
+                insert into blocks (id, hash, size, codec, algorithm, kind, payload, pack_id, pack_offset, pack_length)
+                values (v_block_id, v_hashes[i + 1], sizes[i + 1], v_codecs[i + 1], v_algorithms[i + 1], v_kinds[i + 1], v_payloads[i + 1],
+                        case when v_kinds[i + 1] = 2 then v_packs[i + 1] end, v_offsets[i + 1], v_lengths[i + 1]);
+            end if;
+            insert into links (chain_id, block_id, ordinal) values (v_chain_id, v_block_id, i);
+            i := i + 1;
+        end loop;
+    update files set chain_id = v_chain_id, version = files.version + case when o_chain_id is null then 0 else 1 end
+    where files.path = v_path;
+    return query
+        select * from unnest(array [v_chain_id, o_chain_id]::uuid[]) as u where u <> null_uuid();
+end
+$$;
+
+CREATE FUNCTION public.file_select(v_path text) RETURNS TABLE(block_id uuid, size bigint, codec smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint, mime text, created timestamp with time zone, chain_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select links.block_id, blocks.size, blocks.codec, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, blocks.tier, chains.mime, chains.created,
+               chains.id
+        from files
+                 join chains on chains.id = files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where files.path = v_path
+        order by links.ordinal;
+end
+$$;
@@ -0,0 +1,38 @@
+CREATE FUNCTION public.file_rename(v_from text, v_to text, v_prefix boolean, v_overwrite boolean) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_path      text;
+    v_target    text;
+    v_chain_id  uuid;
+    v_block_ids uuid[] = array []::uuid[];
+    n           int not null default 0;
+begin
+    for v_path in
+        select files.path
+        from files
+        where files.chain_id is not null
+          and (not v_prefix and files.path = v_from or v_prefix and starts_with(files.path, v_from))
+        order by files.path
+        for update
+        loop
+            v_target := v_to || substr(v_path, length(v_from) + 1);
+            delete from files where path = v_target returning files.chain_id into v_chain_id;
+            if found then
+                if not v_overwrite then
+                    raise exception 'file exists' using errcode = 'NC409';
+                end if;
+                if v_chain_id is not null then
+                    v_block_ids := v_block_ids || array(select * from block_delete(v_chain_id));
+                end if;
+            end if;
+            update files set path = v_target where path = v_path;
+            n := n + 1;
+        end loop;
+    if n = 0 then
+        raise exception 'file not found' using errcode = 'NC404';
+    end if;
+    return query
+        select * from unnest(v_block_ids);
+end
+$$;
@@ -0,0 +1,183 @@
+CREATE TABLE public.snapshots (
+    name text NOT NULL,
+    prefix text NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+ALTER TABLE ONLY public.snapshots
+    ADD CONSTRAINT snapshots_pk PRIMARY KEY (name);
+
+CREATE TABLE public.snapshot_files (
+    name text NOT NULL,
+    path text NOT NULL,
+    file_id uuid NOT NULL,
+    chain_id uuid NOT NULL,
+    version integer DEFAULT 0 NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL
+);
+
+ALTER TABLE ONLY public.snapshot_files
+    ADD CONSTRAINT snapshot_files_pk PRIMARY KEY (name, path);
+
+ALTER TABLE ONLY public.snapshot_files
+    ADD CONSTRAINT snapshot_files_name_fk FOREIGN KEY (name) REFERENCES public.snapshots(name) ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.snapshot_files
+    ADD CONSTRAINT snapshot_files_chain_id_fk FOREIGN KEY (chain_id) REFERENCES public.chains(id);
+
+CREATE OR REPLACE PROCEDURE public.block_update(IN v_chain_id uuid, IN o_block_id uuid, IN n_block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set refer = refer + 1 where id = n_block_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update links set block_id = n_block_id where chain_id = v_chain_id and block_id = o_block_id;
+    if not found then
+        raise exception 'not found';
+    end if;
+    update blocks set refer = refer - 1 where id = o_block_id;
+    delete from blocks where id = o_block_id and refer = 0;
+end
+$$;
+
+CREATE FUNCTION public.chain_copy(v_chain_id uuid) RETURNS uuid
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n_chain_id uuid;
+begin
+    insert into chains (mime, metadata, created)
+    select chains.mime, chains.metadata, chains.created from chains where chains.id = v_chain_id
+    returning id into n_chain_id;
+    insert into links (chain_id, block_id, ordinal)
+    select n_chain_id, links.block_id, links.ordinal from links where links.chain_id = v_chain_id;
+    update blocks set refer = blocks.refer + u.n
+    from (select links.block_id, count(*) as n from links where links.chain_id = n_chain_id group by links.block_id) as u
+    where blocks.id = u.block_id;
+    return n_chain_id;
+end
+$$;
+
+CREATE FUNCTION public.snapshot_insert(v_name text, v_prefix text) RETURNS TABLE(name text, prefix text, files integer, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n int;
+begin
+    insert into snapshots (name, prefix) values (v_name, v_prefix) on conflict do nothing;
+    if not found then
+        raise exception 'snapshot exists' using errcode = 'NC409';
+    end if;
+    insert into snapshot_files (name, path, file_id, chain_id, version, created)
+    select v_name, f.path, f.id, chain_copy(f.chain_id), f.version, f.created
+    from files as f
+    where f.chain_id is not null
+      and starts_with(f.path, v_prefix)
+    order by f.path
+    for share;
+    get diagnostics n = row_count;
+    if n = 0 then
+        raise exception 'file not found' using errcode = 'NC404';
+    end if;
+    return query
+        select snapshots.name, snapshots.prefix, n, snapshots.created from snapshots where snapshots.name = v_name;
+end
+$$;
+
+CREATE FUNCTION public.snapshot_select() RETURNS TABLE(name text, prefix text, files integer, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    return query
+        select snapshots.name, snapshots.prefix, count(snapshot_files.path)::integer, snapshots.created
+        from snapshots
+                 left join snapshot_files on snapshot_files.name = snapshots.name
+        group by snapshots.name
+        order by snapshots.name;
+end
+$$;
+
+CREATE FUNCTION public.snapshot_file_select(v_name text, v_path text) RETURNS TABLE(block_id uuid, hash bytea, size bigint, codec smallint, algorithm smallint, kind smallint, payload bytea, pack_id uuid, pack_offset bigint, pack_length bigint, tier smallint, chain_id uuid, mime text, metadata jsonb, modified timestamp with time zone, version integer, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    perform from snapshots where snapshots.name = v_name;
+    if not found then
+        raise exception 'snapshot not found' using errcode = 'NC404';
+    end if;
+    return query
+        select links.block_id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind, blocks.payload,
+               coalesce(blocks.pack_id, null_uuid()), blocks.pack_offset, blocks.pack_length, blocks.tier,
+               chains.id, chains.mime, chains.metadata, chains.created, snapshot_files.version, snapshot_files.created
+        from snapshot_files
+                 join chains on chains.id = snapshot_files.chain_id
+                 join links on chains.id = links.chain_id
+                 join blocks on blocks.id = links.block_id
+        where snapshot_files.name = v_name
+          and snapshot_files.path = v_path
+        order by links.ordinal;
+end
+$$;
+
+CREATE FUNCTION public.snapshot_restore(v_name text) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_file      record;
+    n_chain_id  uuid;
+    o_chain_id  uuid;
+    v_block_ids uuid[] = array []::uuid[];
+    n           int not null default 0;
+begin
+    for v_file in
+        select snapshot_files.path, snapshot_files.file_id, snapshot_files.chain_id, snapshot_files.version, snapshot_files.created
+        from snapshot_files
+        where snapshot_files.name = v_name
+        order by snapshot_files.path
+        loop
+            n_chain_id := chain_copy(v_file.chain_id);
+            select files.chain_id from files where files.path = v_file.path for update into o_chain_id;
+            if found then
+                update files set chain_id = n_chain_id, version = files.version + 1 where files.path = v_file.path;
+                if o_chain_id is not null then
+                    v_block_ids := v_block_ids || array(select * from block_delete(o_chain_id));
+                end if;
+            else
+                insert into files (id, path, chain_id, version, created)
+                values (v_file.file_id, v_file.path, n_chain_id, v_file.version, v_file.created);
+            end if;
+            n := n + 1;
+        end loop;
+    if n = 0 then
+        raise exception 'snapshot not found' using errcode = 'NC404';
+    end if;
+    return query
+        select * from unnest(v_block_ids);
+end
+$$;
+
+CREATE FUNCTION public.snapshot_delete(v_name text) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_chain_id  uuid;
+    v_block_ids uuid[] = array []::uuid[];
+begin
+    perform from snapshots where snapshots.name = v_name for update;
+    if not found then
+        raise exception 'snapshot not found' using errcode = 'NC404';
+    end if;
+    for v_chain_id in delete from snapshot_files where snapshot_files.name = v_name returning snapshot_files.chain_id
+        loop
+            v_block_ids := v_block_ids || array(select * from block_delete(v_chain_id));
+        end loop;
+    delete from snapshots where snapshots.name = v_name;
+    return query
+        select * from unnest(v_block_ids);
+end
+$$;
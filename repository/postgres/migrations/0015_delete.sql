@@ -0,0 +1,14 @@
+CREATE OR REPLACE FUNCTION public.file_delete(v_path text) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_chain_id uuid;
+begin
+    delete from files where path = v_path returning files.chain_id into v_chain_id;
+    if not found then
+        raise exception 'file not found' using errcode = 'NC404';
+    end if;
+    return query
+        select * from block_delete(v_chain_id);
+end
+$$;
@@ -0,0 +1,11 @@
+DROP PROCEDURE public.block_move(v_block_id uuid, v_tier smallint);
+
+CREATE FUNCTION public.block_move(v_block_id uuid, v_from smallint, v_to smallint) RETURNS boolean
+    LANGUAGE plpgsql
+    AS $$
+declare
+begin
+    update blocks set tier = v_to where id = v_block_id and tier = v_from;
+    return found;
+end
+$$;
@@ -0,0 +1,64 @@
+ALTER TABLE public.snapshot_files DROP COLUMN file_id;
+
+CREATE OR REPLACE FUNCTION public.snapshot_insert(v_name text, v_prefix text) RETURNS TABLE(name text, prefix text, files integer, created timestamp with time zone)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    n int;
+begin
+    insert into snapshots (name, prefix) values (v_name, v_prefix) on conflict do nothing;
+    if not found then
+        raise exception 'snapshot exists' using errcode = 'NC409';
+    end if;
+    insert into snapshot_files (name, path, chain_id, version, created)
+    select v_name, f.path, chain_copy(f.chain_id), f.version, f.created
+    from files as f
+    where f.chain_id is not null
+      and starts_with(f.path, v_prefix)
+    order by f.path
+    for share;
+    get diagnostics n = row_count;
+    if n = 0 then
+        raise exception 'file not found' using errcode = 'NC404';
+    end if;
+    return query
+        select snapshots.name, snapshots.prefix, n, snapshots.created from snapshots where snapshots.name = v_name;
+end
+$$;
+
+CREATE OR REPLACE FUNCTION public.snapshot_restore(v_name text) RETURNS TABLE(block_id uuid)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_file      record;
+    n_chain_id  uuid;
+    o_chain_id  uuid;
+    v_block_ids uuid[] = array []::uuid[];
+    n           int not null default 0;
+begin
+    for v_file in
+        select snapshot_files.path, snapshot_files.chain_id, snapshot_files.version, snapshot_files.created
+        from snapshot_files
+        where snapshot_files.name = v_name
+        order by snapshot_files.path
+        loop
+            n_chain_id := chain_copy(v_file.chain_id);
+            select files.chain_id from files where files.path = v_file.path for update into o_chain_id;
+            if found then
+                update files set chain_id = n_chain_id, version = files.version + 1 where files.path = v_file.path;
+                if o_chain_id is not null then
+                    v_block_ids := v_block_ids || array(select * from block_delete(o_chain_id));
+                end if;
+            else
+                insert into files (path, chain_id, version, created)
+                values (v_file.path, n_chain_id, v_file.version, v_file.created);
+            end if;
+            n := n + 1;
+        end loop;
+    if n = 0 then
+        raise exception 'snapshot not found' using errcode = 'NC404';
+    end if;
+    return query
+        select * from unnest(v_block_ids);
+end
+$$;
@@ -0,0 +1,40 @@
+DROP FUNCTION public.file_rename(text, text, boolean, boolean);
+
+CREATE FUNCTION public.file_rename(v_from text, v_to text, v_prefix boolean, v_overwrite boolean, OUT block_ids uuid[], OUT replaced boolean)
+    LANGUAGE plpgsql
+    AS $$
+declare
+    v_path     text;
+    v_target   text;
+    v_chain_id uuid;
+    n          int not null default 0;
+begin
+    block_ids := array []::uuid[];
+    replaced := false;
+    for v_path in
+        select files.path
+        from files
+        where files.chain_id is not null
+          and (not v_prefix and files.path = v_from or v_prefix and starts_with(files.path, v_from))
+        order by files.path
+        for update
+        loop
+            v_target := v_to || substr(v_path, length(v_from) + 1);
+            delete from files where path = v_target returning files.chain_id into v_chain_id;
+            if found then
+                if not v_overwrite then
+                    raise exception 'file exists' using errcode = 'NC409';
+                end if;
+                replaced := true;
+                if v_chain_id is not null then
+                    block_ids := block_ids || array(select * from block_delete(v_chain_id));
+                end if;
+            end if;
+            update files set path = v_target where path = v_path;
+            n := n + 1;
+        end loop;
+    if n = 0 then
+        raise exception 'file not found' using errcode = 'NC404';
+    end if;
+end
+$$;
@@ -2,11 +2,15 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jmoiron/sqlx"
 
 	"github.com/pshvedko/nocopy/api"
@@ -16,23 +20,95 @@ type Repository struct {
 	db *sqlx.DB
 }
 
-func (r *Repository) Put(ctx context.Context, path string) (fid uuid.UUID, err error) {
-	err = r.db.GetContext(ctx, &fid, "select * from file_insert($1)", path)
+func (r *Repository) Link(ctx context.Context, cid uuid.UUID, bid1 uuid.UUID, bid2 uuid.UUID) (err error) {
+	_, err = r.db.ExecContext(ctx, "call block_update($1, $2, $3)", cid, bid1, bid2)
 	return
 }
 
-func (r *Repository) Link(ctx context.Context, cid uuid.UUID, bid1 uuid.UUID, bid2 uuid.UUID) (err error) {
-	_, err = r.db.ExecContext(ctx, "call block_update($1, $2, $3)", cid, bid1, bid2)
+func (r *Repository) Update(ctx context.Context, object api.Object, match uuid.UUID, linked []bool) (chains []uuid.UUID, err error) {
+	blocks := object.Blocks
+	ids := make([]uuid.UUID, len(blocks))
+	hashes := make([]api.Hash, len(blocks))
+	sizes := make([]int64, len(blocks))
+	codecs := make([]api.Codec, len(blocks))
+	algorithms := make([]api.Algorithm, len(blocks))
+	kinds := make([]api.Kind, len(blocks))
+	payloads := make([][]byte, len(blocks))
+	packs := make([]uuid.UUID, len(blocks))
+	offsets := make([]int64, len(blocks))
+	lengths := make([]int64, len(blocks))
+	for i, b := range blocks {
+		ids[i], hashes[i], sizes[i], codecs[i], algorithms[i] = b.ID, b.Hash, b.Size, b.Codec, b.Algorithm
+		kinds[i], payloads[i], packs[i], offsets[i], lengths[i] = b.Kind, b.Payload, b.Pack, b.Offset, b.Length
+	}
+	metadata, err := json.Marshal(object.Metadata)
+	if err != nil {
+		return
+	}
+	err = r.db.SelectContext(ctx, &chains, "select * from block_insert($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)",
+		object.Key, object.Chain, match, object.Type, string(metadata),
+		ids, hashes, sizes, codecs, algorithms, kinds, payloads, packs, offsets, lengths, linked)
+	err = Convert(err)
+	return
+}
+
+func (r *Repository) Sparse(ctx context.Context, ratio float64, limit int) (packs []api.Pack, err error) {
+	err = r.db.SelectContext(ctx, &packs, "select * from pack_select($1, $2)", ratio, limit)
+	return
+}
+
+func (r *Repository) Unpack(ctx context.Context, pid uuid.UUID) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from pack_blocks($1)", pid)
+	return
+}
+
+func (r *Repository) Repack(ctx context.Context, pid1 uuid.UUID, pid2 uuid.UUID, blocks []uuid.UUID, offsets []int64) (err error) {
+	_, err = r.db.ExecContext(ctx, "call pack_update($1, $2, $3, $4)", pid1, pid2, blocks, offsets)
+	return
+}
+
+func (r *Repository) Scan(ctx context.Context, after uuid.UUID, limit int) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from block_scan($1, $2)", after, limit)
+	return
+}
+
+func (r *Repository) Exist(ctx context.Context, ids []uuid.UUID) (known []uuid.UUID, err error) {
+	err = r.db.SelectContext(ctx, &known, "select * from object_select($1)", ids)
+	return
+}
+
+func (r *Repository) Check(ctx context.Context, bid uuid.UUID, corrupt bool) (err error) {
+	_, err = r.db.ExecContext(ctx, "call block_check($1, $2)", bid, corrupt)
+	return
+}
+
+func (r *Repository) Affected(ctx context.Context, bid uuid.UUID) (names []string, err error) {
+	err = r.db.SelectContext(ctx, &names, "select * from file_affected($1)", bid)
+	return
+}
+
+func (r *Repository) Touch(ctx context.Context, blocks []uuid.UUID) (err error) {
+	_, err = r.db.ExecContext(ctx, "call block_touch($1)", blocks)
+	return
+}
+
+func (r *Repository) Stale(ctx context.Context, before time.Time, tier api.Tier, limit int) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from block_stale($1, $2, $3)", before, tier, limit)
 	return
 }
 
-func (r *Repository) Update(ctx context.Context, fid uuid.UUID, blocks []uuid.UUID, hashes []api.Hash, sizes []int64) (chains []uuid.UUID, err error) {
-	err = r.db.SelectContext(ctx, &chains, "select * from block_insert($1, $2, $3, $4)", fid, blocks, hashes, sizes)
+func (r *Repository) Move(ctx context.Context, bid uuid.UUID, from, to api.Tier) (moved bool, err error) {
+	err = r.db.GetContext(ctx, &moved, "select block_move($1, $2, $3)", bid, from, to)
 	return
 }
 
-func (r *Repository) Lookup(ctx context.Context, hash api.Hash, size int64) (blocks []uuid.UUID, err error) {
-	err = r.db.SelectContext(ctx, &blocks, "select * from block_select($1, $2)", hash, size)
+func (r *Repository) Rehash(ctx context.Context, bid uuid.UUID, algorithm api.Algorithm, hash api.Hash) (err error) {
+	_, err = r.db.ExecContext(ctx, "call block_rehash($1, $2, $3)", bid, algorithm, hash)
+	return
+}
+
+func (r *Repository) Lookup(ctx context.Context, algorithm api.Algorithm, hash api.Hash, size int64) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from block_select($1, $2, $3)", hash, size, algorithm)
 	return
 }
 
@@ -41,40 +117,102 @@ func (r *Repository) Break(ctx context.Context, cid uuid.UUID) (blocks []uuid.UU
 	return
 }
 
-func (r *Repository) Delete(ctx context.Context, path string) (blocks []uuid.UUID, err error) {
-	err = r.db.SelectContext(ctx, &blocks, "select * from file_delete($1)", path)
+func (r *Repository) Delete(ctx context.Context, path string) (blocks []uuid.UUID, found bool, err error) {
+	err = Convert(r.db.SelectContext(ctx, &blocks, "select * from file_delete($1)", path))
+	if errors.Is(err, api.ErrNotExist) {
+		return nil, false, nil
+	}
+	return blocks, err == nil, err
+}
+
+func (r *Repository) Rename(ctx context.Context, from string, to string, prefix bool, overwrite bool) (blocks []uuid.UUID, replaced bool, err error) {
+	err = r.db.QueryRowContext(ctx, "select * from file_rename($1, $2, $3, $4)", from, to, prefix, overwrite).
+		Scan(pgtype.NewMap().SQLScanner(&blocks), &replaced)
+	err = Convert(err)
 	return
 }
 
-func (r *Repository) Get(ctx context.Context, name string) (
-	mime string,
-	date time.Time,
-	size int64,
-	blocks []uuid.UUID,
-	sizes []int64,
-	err error) {
-	rows, err := r.db.QueryContext(ctx, "select * from file_select($1)", name)
+func (r *Repository) Get(ctx context.Context, name string) (api.Object, error) {
+	return r.Select(ctx, name, "select * from file_select($1)", name)
+}
+
+// Select reads the object of a stored function returning its blocks in order along with the chain and the file they
+// belong to.
+func (r *Repository) Select(ctx context.Context, name string, query string, args ...any) (object api.Object, err error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
+		err = Convert(err)
 		return
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
-	var n int
+	object.Key = name
+	var metadata []byte
 	for rows.Next() {
-		sizes = append(sizes, size)
-		blocks = append(blocks, uuid.UUID{})
-		err = rows.Scan(&blocks[n], &sizes[n], &mime, &date)
+		var b api.Block
+		err = rows.Scan(&b.ID, &b.Hash, &b.Size, &b.Codec, &b.Algorithm, &b.Kind, &b.Payload, &b.Pack, &b.Offset, &b.Length, &b.Tier,
+			&object.Chain, &object.Type, &metadata, &object.Modified, &object.Version, &object.Created)
 		if err != nil {
-			break
+			return
 		}
-		size += sizes[n]
-		n++
+		object.Blocks = append(object.Blocks, b)
 	}
-	err = rows.Err()
+	err = Convert(rows.Err())
+	if err != nil || len(object.Blocks) == 0 {
+		return
+	}
+	object.Complete()
+	err = json.Unmarshal(metadata, &object.Metadata)
+	return
+}
+
+func (r *Repository) Snapshot(ctx context.Context, name string, prefix string) (snapshot api.Snapshot, err error) {
+	err = r.db.QueryRowxContext(ctx, "select * from snapshot_insert($1, $2)", name, prefix).
+		Scan(&snapshot.Name, &snapshot.Prefix, &snapshot.Files, &snapshot.Created)
+	err = Convert(err)
+	return
+}
+
+func (r *Repository) Snapshots(ctx context.Context) (snapshots []api.Snapshot, err error) {
+	err = r.db.SelectContext(ctx, &snapshots, "select * from snapshot_select()")
 	return
 }
 
+func (r *Repository) View(ctx context.Context, name string, path string) (api.Object, error) {
+	return r.Select(ctx, path, "select * from snapshot_file_select($1, $2)", name, path)
+}
+
+func (r *Repository) Restore(ctx context.Context, name string) (blocks []uuid.UUID, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from snapshot_restore($1)", name)
+	err = Convert(err)
+	return
+}
+
+func (r *Repository) Release(ctx context.Context, name string) (blocks []uuid.UUID, err error) {
+	err = r.db.SelectContext(ctx, &blocks, "select * from snapshot_delete($1)", name)
+	err = Convert(err)
+	return
+}
+
+// Convert turns the error codes raised by the stored functions into the errors of api.
+func Convert(err error) error {
+	var e *pgconn.PgError
+	if !errors.As(err, &e) {
+		return err
+	}
+	switch e.Code {
+	case "NC404":
+		return api.ErrNotExist
+	case "NC409":
+		return api.ErrExist
+	case "NC412":
+		return api.ErrPrecondition
+	default:
+		return err
+	}
+}
+
 func (r *Repository) Shutdown() {
 	if r == nil {
 		return
@@ -83,7 +221,15 @@ func (r *Repository) Shutdown() {
 }
 
 func New(u *url.URL) (*Repository, error) {
-	db, err := sqlx.Open("pgx", u.String())
+	q := u.Query()
+	migrate, err := strconv.ParseBool(q.Get("migrate"))
+	if err != nil {
+		migrate = true
+	}
+	q.Del("migrate")
+	v := *u
+	v.RawQuery = q.Encode()
+	db, err := sqlx.Open("pgx", v.String())
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +247,13 @@ func New(u *url.URL) (*Repository, error) {
 	}
 	db.SetMaxOpenConns(mo)
 	db.SetMaxIdleConns(mi)
+	if migrate {
+		_, err = Migrate(context.Background(), db)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
 	return &Repository{
 		db: db,
 	}, nil
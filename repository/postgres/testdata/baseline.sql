@@ -2,7 +2,6 @@
 -- PostgreSQL database dump
 --
 
-\restrict N1WgrslCt18117ErVTVId9dhd42MMr0FtTIYkAJOGrJck7CDGs3WtvcQllig9wf
 
 -- Dumped from database version 17.7
 -- Dumped by pg_dump version 17.7
@@ -362,5 +361,4 @@ ALTER TABLE ONLY public.links
 -- PostgreSQL database dump complete
 --
 
-\unrestrict N1WgrslCt18117ErVTVId9dhd42MMr0FtTIYkAJOGrJck7CDGs3WtvcQllig9wf
 
@@ -9,17 +9,73 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository/mem"
 	"github.com/pshvedko/nocopy/repository/postgres"
+	"github.com/pshvedko/nocopy/repository/sqlite"
 )
 
-type Repository interface {
-	Put(context.Context, string) (uuid.UUID, error)
-	Get(context.Context, string) (string, time.Time, int64, []uuid.UUID, []int64, error)
-	Lookup(context.Context, api.Hash, int64) ([]uuid.UUID, error)
+// Collector tells the objects of the storage the repository still refers to.
+type Collector interface {
+	Exist(context.Context, []uuid.UUID) ([]uuid.UUID, error)
+}
+
+// Publisher publishes files and resolves the blocks of their content.
+type Publisher interface {
+	Collector
+	Get(context.Context, string) (api.Object, error)
+	Lookup(context.Context, api.Algorithm, api.Hash, int64) ([]api.Block, error)
 	Link(context.Context, uuid.UUID, uuid.UUID, uuid.UUID) error
 	Break(context.Context, uuid.UUID) ([]uuid.UUID, error)
-	Update(context.Context, uuid.UUID, []uuid.UUID, []api.Hash, []int64) ([]uuid.UUID, error)
-	Delete(context.Context, string) ([]uuid.UUID, error)
+	Update(context.Context, api.Object, uuid.UUID, []bool) ([]uuid.UUID, error)
+	Delete(context.Context, string) ([]uuid.UUID, bool, error)
+	Rename(context.Context, string, string, bool, bool) ([]uuid.UUID, bool, error)
+}
+
+// Rehasher walks the blocks to record them under another hash algorithm.
+type Rehasher interface {
+	Scan(context.Context, uuid.UUID, int) ([]api.Block, error)
+	Rehash(context.Context, uuid.UUID, api.Algorithm, api.Hash) error
+}
+
+// Scrubber walks the blocks to record their checks and the files a corrupt one affects.
+type Scrubber interface {
+	Scan(context.Context, uuid.UUID, int) ([]api.Block, error)
+	Check(context.Context, uuid.UUID, bool) error
+	Affected(context.Context, uuid.UUID) ([]string, error)
+}
+
+// Compactor rewrites the packs most of whose blocks are gone.
+type Compactor interface {
+	Sparse(context.Context, float64, int) ([]api.Pack, error)
+	Unpack(context.Context, uuid.UUID) ([]api.Block, error)
+	Repack(context.Context, uuid.UUID, uuid.UUID, []uuid.UUID, []int64) error
+}
+
+// Mover records block reads and moves the blocks not read for a while between tiers.
+type Mover interface {
+	Collector
+	Touch(context.Context, []uuid.UUID) error
+	Stale(context.Context, time.Time, api.Tier, int) ([]api.Block, error)
+	Move(context.Context, uuid.UUID, api.Tier, api.Tier) (bool, error)
+}
+
+// Snapshotter pins the files under a prefix and points them back at their pinned content.
+type Snapshotter interface {
+	Snapshot(context.Context, string, string) (api.Snapshot, error)
+	Snapshots(context.Context) ([]api.Snapshot, error)
+	View(context.Context, string, string) (api.Object, error)
+	Restore(context.Context, string) ([]uuid.UUID, error)
+	Release(context.Context, string) ([]uuid.UUID, error)
+}
+
+// Repository is what every backend implements, the jobs only need the part of it they work with.
+type Repository interface {
+	Publisher
+	Rehasher
+	Scrubber
+	Compactor
+	Mover
+	Snapshotter
 	Shutdown()
 }
 
@@ -31,6 +87,10 @@ func New(name string) (Repository, error) {
 	switch u.Scheme {
 	case "postgres":
 		return postgres.New(u)
+	case "mem":
+		return mem.New(u)
+	case "sqlite":
+		return sqlite.New(u)
 	default:
 		return nil, errors.New("invalid repository scheme")
 	}
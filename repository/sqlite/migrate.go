@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// Migrations returns the embedded migrations ordered by version, the version is the numeric prefix of the file name.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []Migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration %q", e.Name())
+		}
+		b, err := migrations.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: e.Name(), Query: string(b)})
+	}
+	slices.SortFunc(list, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", list[i].Version)
+		}
+	}
+	return list, nil
+}
+
+// Migrate applies every migration newer than the user_version of the database, each one in its own transaction.
+// The first migration creates the tables only if missing, so a database made before the versioning starts from it.
+func Migrate(ctx context.Context, db *sqlx.DB) (version int, err error) {
+	list, err := Migrations()
+	if err != nil {
+		return
+	}
+	for _, m := range list {
+		var applied bool
+		applied, err = apply(ctx, db, m)
+		if err != nil {
+			return version, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		version = m.Version
+		if applied {
+			slog.Info("migrate", "version", version, "name", m.Name)
+		}
+	}
+	return
+}
+
+// apply runs a migration unless the database is at its version already, the version is read within the transaction as
+// another process may be migrating the same file.
+func apply(ctx context.Context, db *sqlx.DB, m Migration) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	var version int
+	err = tx.GetContext(ctx, &version, "pragma user_version")
+	if err == nil && version >= m.Version {
+		return false, tx.Commit()
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, m.Query)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("pragma user_version = %d", m.Version))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,52 @@
+package sqlite
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+	list, err := Migrations()
+	require.NoError(t, err)
+	for i, m := range list {
+		require.Equal(t, i+1, m.Version)
+	}
+	name := filepath.Join(t.TempDir(), "nocopy.db")
+	db, err := sqlx.Open("sqlite", name)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, list[0].Query)
+	require.NoError(t, err)
+	chain := uuid.New()
+	_, err = db.ExecContext(ctx, `insert into chains (id, created) values ($1, 0)`, chain)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `insert into files (id, path, chain_id) values ($1, '/a', $2)`, uuid.New(), chain)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	for i := 0; i < 2; i++ {
+		r, err := New(&url.URL{Scheme: "sqlite", Path: name})
+		require.NoError(t, err)
+		var version int
+		require.NoError(t, r.db.GetContext(ctx, &version, "pragma user_version"))
+		require.Equal(t, len(list), version)
+		block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 2, Kind: api.KindInline, Payload: []byte("ab")}
+		chains, err := r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Metadata: map[string]string{"k": "v"},
+			Blocks: []api.Block{block}}, api.Any, []bool{false})
+		require.NoError(t, err)
+		require.Len(t, chains, 2)
+		object, err := r.Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, chains[0], object.Chain)
+		require.Equal(t, map[string]string{"k": "v"}, object.Metadata)
+		r.Shutdown()
+	}
+}
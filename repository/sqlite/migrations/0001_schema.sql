@@ -0,0 +1,64 @@
+create table if not exists blocks
+(
+    id          text primary key,
+    hash        blob    not null,
+    size        integer not null,
+    refer       integer not null default 1,
+    updated     integer not null,
+    codec       integer not null default 0,
+    algorithm   integer not null default 0,
+    kind        integer not null default 0,
+    payload     blob,
+    pack_id     text,
+    pack_offset integer not null default 0,
+    pack_length integer not null default 0,
+    checked     integer,
+    corrupt     integer not null default 0,
+    accessed    integer not null,
+    tier        integer not null default 0
+);
+
+create index if not exists blocks_hash_idx on blocks (hash, size, algorithm);
+create index if not exists blocks_pack_id_idx on blocks (pack_id);
+create index if not exists blocks_accessed_idx on blocks (tier, accessed) where kind = 0;
+
+create table if not exists chains
+(
+    id      text primary key,
+    mime    text    not null default '',
+    created integer not null
+);
+
+create table if not exists files
+(
+    id       text primary key,
+    path     text    not null unique,
+    chain_id text,
+    version  integer not null default 0
+);
+
+create table if not exists links
+(
+    chain_id text    not null references chains (id) on delete cascade,
+    block_id text    not null,
+    ordinal  integer not null
+);
+
+create index if not exists links_chain_id_idx on links (chain_id, ordinal);
+create index if not exists links_block_id_idx on links (block_id);
+
+create table if not exists packs
+(
+    id      text primary key,
+    size    integer not null default 0,
+    live    integer not null default 0,
+    created integer not null
+);
+
+create trigger if not exists blocks_pack_forget
+    after delete
+    on blocks
+    when old.pack_id is not null
+begin
+    update packs set live = live - old.pack_length where id = old.pack_id;
+end;
@@ -0,0 +1,3 @@
+alter table chains add column metadata text not null default '{}';
+
+alter table files add column created integer not null default 0;
@@ -0,0 +1,17 @@
+create table snapshots
+(
+    name    text primary key,
+    prefix  text    not null,
+    created integer not null
+);
+
+create table snapshot_files
+(
+    name     text    not null references snapshots (name) on delete cascade,
+    path     text    not null,
+    file_id  text    not null,
+    chain_id text    not null,
+    version  integer not null default 0,
+    created  integer not null default 0,
+    primary key (name, path)
+);
@@ -0,0 +1 @@
+alter table snapshot_files drop column file_id;
@@ -0,0 +1,606 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+var ErrNotFound = errors.New("not found")
+
+const columns = `id, hash, size, codec, algorithm, kind, payload, pack_id, pack_offset, pack_length, tier`
+
+// Repository keeps the schema of the postgres repository in a single sqlite file, the stored functions of postgres
+// are done in go within transactions.
+type Repository struct {
+	db *sqlx.DB
+}
+
+func (r *Repository) Transact(ctx context.Context, f func(*sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) Get(ctx context.Context, path string) (api.Object, error) {
+	return r.Select(ctx, path, `select links.block_id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind,
+		blocks.payload, blocks.pack_id, blocks.pack_offset, blocks.pack_length, blocks.tier,
+		chains.id, chains.mime, chains.metadata, chains.created, files.version, files.created
+		from files
+		join chains on chains.id = files.chain_id
+		join links on links.chain_id = chains.id
+		join blocks on blocks.id = links.block_id
+		where files.path = $1
+		order by links.ordinal`, path)
+}
+
+// Select reads the object of a query returning its blocks in order along with the chain and the file they belong to.
+func (r *Repository) Select(ctx context.Context, path string, query string, args ...any) (object api.Object, err error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	object.Key = path
+	var metadata string
+	var modified, created int64
+	for rows.Next() {
+		var b api.Block
+		err = rows.Scan(&b.ID, &b.Hash, &b.Size, &b.Codec, &b.Algorithm, &b.Kind, &b.Payload, &b.Pack, &b.Offset, &b.Length, &b.Tier,
+			&object.Chain, &object.Type, &metadata, &modified, &object.Version, &created)
+		if err != nil {
+			return
+		}
+		object.Blocks = append(object.Blocks, b)
+	}
+	err = rows.Err()
+	if err != nil || len(object.Blocks) == 0 {
+		return
+	}
+	object.Modified, object.Created = time.Unix(0, modified), time.Unix(0, created)
+	object.Complete()
+	err = json.Unmarshal([]byte(metadata), &object.Metadata)
+	return
+}
+
+func (r *Repository) Lookup(ctx context.Context, algorithm api.Algorithm, hash api.Hash, size int64) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, `select id, codec, kind, pack_id, pack_offset, pack_length, tier from blocks
+		where hash = $1 and size = $2 and algorithm = $3 and kind <> 1 and not corrupt
+		order by refer desc, updated, id`, hash, size, algorithm)
+	return
+}
+
+func (r *Repository) Link(ctx context.Context, cid uuid.UUID, bid1 uuid.UUID, bid2 uuid.UUID) error {
+	return r.Transact(ctx, func(tx *sqlx.Tx) error {
+		err := Affect(tx.ExecContext(ctx, `update blocks set refer = refer + 1 where id = $1`, bid2))
+		if err != nil {
+			return err
+		}
+		err = Affect(tx.ExecContext(ctx, `update links set block_id = $1 where chain_id = $2 and block_id = $3`, bid2, cid, bid1))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `update blocks set refer = refer - 1 where id = $1`, bid1)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `delete from blocks where id = $1 and refer = 0`, bid1)
+		return err
+	})
+}
+
+func (r *Repository) Break(ctx context.Context, cid uuid.UUID) (blocks []uuid.UUID, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		blocks, err = Drop(ctx, tx, cid)
+		return err
+	})
+	return
+}
+
+// Drop removes a chain and returns the storage blocks nobody refers to anymore.
+func Drop(ctx context.Context, tx *sqlx.Tx, cid uuid.UUID) (blocks []uuid.UUID, err error) {
+	var ids []uuid.UUID
+	err = tx.SelectContext(ctx, &ids, `select block_id from links where chain_id = $1 order by ordinal`, cid)
+	if err != nil {
+		return
+	}
+	_, err = tx.ExecContext(ctx, `delete from links where chain_id = $1`, cid)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		var refer int
+		var kind api.Kind
+		err = tx.QueryRowxContext(ctx, `update blocks set refer = refer - 1 where id = $1 returning refer, kind`, id).Scan(&refer, &kind)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return
+		}
+		if refer > 0 {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `delete from blocks where id = $1 and refer = 0`, id)
+		if err != nil {
+			return
+		}
+		if kind == api.KindStorage {
+			blocks = append(blocks, id)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `delete from chains where id = $1`, cid)
+	return
+}
+
+func (r *Repository) Update(ctx context.Context, object api.Object, match uuid.UUID, linked []bool) (chains []uuid.UUID, err error) {
+	path, cid, blocks := object.Key, object.Chain, object.Blocks
+	metadata, err := json.Marshal(object.Metadata)
+	if err != nil {
+		return
+	}
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var old uuid.UUID
+		err := tx.GetContext(ctx, &old, `select chain_id from files where path = $1`, path)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if !api.Match(old, match) {
+			return api.ErrPrecondition
+		}
+		now := time.Now().UnixNano()
+		_, err = tx.ExecContext(ctx, `insert into chains (id, mime, metadata, created) values ($1, $2, $3, $4)`,
+			cid, object.Type, string(metadata), now)
+		if err != nil {
+			return err
+		}
+		for i, b := range blocks {
+			if linked[i] {
+				err = Affect(tx.ExecContext(ctx, `update blocks set refer = refer + 1 where id = $1`, b.ID))
+				if err != nil {
+					return err
+				}
+			} else {
+				var pack any
+				if b.Kind == api.KindPacked {
+					_, err = tx.ExecContext(ctx, `insert into packs (id, size, live, created) values ($1, $2, $2, $3)
+						on conflict (id) do update set size = packs.size + excluded.size, live = packs.live + excluded.live`,
+						b.Pack, b.Length, now)
+					if err != nil {
+						return err
+					}
+					pack = b.Pack
+				}
+				_, err = tx.ExecContext(ctx, `insert into blocks
+					(id, hash, size, updated, codec, algorithm, kind, payload, pack_id, pack_offset, pack_length, accessed)
+					values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $4)`,
+					b.ID, b.Hash, b.Size, now, b.Codec, b.Algorithm, b.Kind, b.Payload, pack, b.Offset, b.Length)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = tx.ExecContext(ctx, `insert into links (chain_id, block_id, ordinal) values ($1, $2, $3)`, cid, b.ID, i)
+			if err != nil {
+				return err
+			}
+		}
+		// as in postgres the file keeps the time it was first published, overwrites only count in its version
+		_, err = tx.ExecContext(ctx, `insert into files (id, path, chain_id, created) values ($1, $2, $3, $4)
+			on conflict (path) do update set chain_id = excluded.chain_id,
+			version = files.version + (files.chain_id is not null)`, uuid.New(), path, cid, now)
+		if err != nil {
+			return err
+		}
+		chains = []uuid.UUID{cid}
+		if old != uuid.Nil {
+			chains = append(chains, old)
+		}
+		return nil
+	})
+	return
+}
+
+func (r *Repository) Scan(ctx context.Context, after uuid.UUID, limit int) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, `select `+columns+` from blocks where id > $1 order by id limit $2`, after, limit)
+	return
+}
+
+func (r *Repository) Rehash(ctx context.Context, bid uuid.UUID, algorithm api.Algorithm, hash api.Hash) error {
+	return Affect(r.db.ExecContext(ctx, `update blocks set algorithm = $1, hash = $2 where id = $3`, algorithm, hash, bid))
+}
+
+func (r *Repository) Delete(ctx context.Context, path string) (blocks []uuid.UUID, found bool, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var cid uuid.UUID
+		err := tx.GetContext(ctx, &cid, `delete from files where path = $1 returning chain_id`, path)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		found = true
+		blocks, err = Drop(ctx, tx, cid)
+		return err
+	})
+	return
+}
+
+func (r *Repository) Rename(ctx context.Context, from string, to string, prefix bool, overwrite bool) (blocks []uuid.UUID, replaced bool, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var names []string
+		err := tx.SelectContext(ctx, &names, `select path from files
+			where chain_id is not null and (not $2 and path = $1 or $2 and substr(path, 1, length($1)) = $1)
+			order by path`, from, prefix)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return api.ErrNotExist
+		}
+		for _, path := range names {
+			target := to + path[len(from):]
+			var cid uuid.UUID
+			err = tx.GetContext(ctx, &cid, `delete from files where path = $1 returning chain_id`, target)
+			if err == nil {
+				if !overwrite {
+					return api.ErrExist
+				}
+				var ids []uuid.UUID
+				ids, err = Drop(ctx, tx, cid)
+				if err != nil {
+					return err
+				}
+				blocks = append(blocks, ids...)
+				replaced = true
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `update files set path = $1 where path = $2`, target, path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (r *Repository) Snapshot(ctx context.Context, name string, prefix string) (snapshot api.Snapshot, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		err := Affect(tx.ExecContext(ctx, `insert into snapshots (name, prefix, created) values ($1, $2, $3) on conflict (name) do nothing`,
+			name, prefix, now.UnixNano()))
+		if errors.Is(err, ErrNotFound) {
+			return api.ErrExist
+		} else if err != nil {
+			return err
+		}
+		var files []struct {
+			Path    string
+			Chain   uuid.UUID `db:"chain_id"`
+			Version int
+			Created int64
+		}
+		err = tx.SelectContext(ctx, &files, `select path, chain_id, version, created from files
+			where chain_id is not null and substr(path, 1, length($1)) = $1
+			order by path`, prefix)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return api.ErrNotExist
+		}
+		for _, f := range files {
+			var cid uuid.UUID
+			cid, err = Copy(ctx, tx, f.Chain)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `insert into snapshot_files (name, path, chain_id, version, created)
+				values ($1, $2, $3, $4, $5)`, name, f.Path, cid, f.Version, f.Created)
+			if err != nil {
+				return err
+			}
+		}
+		snapshot = api.Snapshot{Name: name, Prefix: prefix, Files: len(files), Created: now}
+		return nil
+	})
+	return
+}
+
+// Copy makes a new chain of the same blocks, so that they outlive the original one.
+func Copy(ctx context.Context, tx *sqlx.Tx, cid uuid.UUID) (id uuid.UUID, err error) {
+	id = uuid.New()
+	_, err = tx.ExecContext(ctx, `insert into chains (id, mime, metadata, created) select $1, mime, metadata, created from chains where id = $2`,
+		id, cid)
+	if err != nil {
+		return
+	}
+	_, err = tx.ExecContext(ctx, `insert into links (chain_id, block_id, ordinal) select $1, block_id, ordinal from links where chain_id = $2`,
+		id, cid)
+	if err != nil {
+		return
+	}
+	_, err = tx.ExecContext(ctx, `update blocks set refer = refer + (select count(*) from links where chain_id = $1 and block_id = blocks.id)
+		where id in (select block_id from links where chain_id = $1)`, id)
+	return
+}
+
+func (r *Repository) Snapshots(ctx context.Context) (snapshots []api.Snapshot, err error) {
+	rows, err := r.db.QueryContext(ctx, `select snapshots.name, snapshots.prefix, count(snapshot_files.path), snapshots.created
+		from snapshots
+		left join snapshot_files on snapshot_files.name = snapshots.name
+		group by snapshots.name
+		order by snapshots.name`)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var s api.Snapshot
+		var created int64
+		err = rows.Scan(&s.Name, &s.Prefix, &s.Files, &created)
+		if err != nil {
+			return
+		}
+		s.Created = time.Unix(0, created)
+		snapshots = append(snapshots, s)
+	}
+	err = rows.Err()
+	return
+}
+
+func (r *Repository) View(ctx context.Context, name string, path string) (object api.Object, err error) {
+	var ok bool
+	err = r.db.GetContext(ctx, &ok, `select exists(select 1 from snapshots where name = $1)`, name)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return object, api.ErrNotExist
+	}
+	return r.Select(ctx, path, `select links.block_id, blocks.hash, blocks.size, blocks.codec, blocks.algorithm, blocks.kind,
+		blocks.payload, blocks.pack_id, blocks.pack_offset, blocks.pack_length, blocks.tier,
+		chains.id, chains.mime, chains.metadata, chains.created, snapshot_files.version, snapshot_files.created
+		from snapshot_files
+		join chains on chains.id = snapshot_files.chain_id
+		join links on links.chain_id = chains.id
+		join blocks on blocks.id = links.block_id
+		where snapshot_files.name = $1 and snapshot_files.path = $2
+		order by links.ordinal`, name, path)
+}
+
+func (r *Repository) Restore(ctx context.Context, name string) (blocks []uuid.UUID, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var files []struct {
+			Path    string
+			Chain   uuid.UUID `db:"chain_id"`
+			Version int
+			Created int64
+		}
+		err := tx.SelectContext(ctx, &files, `select path, chain_id, version, created from snapshot_files
+			where name = $1
+			order by path`, name)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return api.ErrNotExist
+		}
+		for _, f := range files {
+			var cid, old uuid.UUID
+			cid, err = Copy(ctx, tx, f.Chain)
+			if err != nil {
+				return err
+			}
+			err = tx.GetContext(ctx, &old, `select chain_id from files where path = $1`, f.Path)
+			if errors.Is(err, sql.ErrNoRows) {
+				_, err = tx.ExecContext(ctx, `insert into files (id, path, chain_id, version, created) values ($1, $2, $3, $4, $5)`,
+					uuid.New(), f.Path, cid, f.Version, f.Created)
+				if err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `update files set chain_id = $1, version = version + 1 where path = $2`, cid, f.Path)
+			if err != nil {
+				return err
+			}
+			var ids []uuid.UUID
+			ids, err = Drop(ctx, tx, old)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, ids...)
+		}
+		return nil
+	})
+	return
+}
+
+func (r *Repository) Release(ctx context.Context, name string) (blocks []uuid.UUID, err error) {
+	err = r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var chains []uuid.UUID
+		err := tx.SelectContext(ctx, &chains, `select chain_id from snapshot_files where name = $1 order by path`, name)
+		if err != nil {
+			return err
+		}
+		err = Affect(tx.ExecContext(ctx, `delete from snapshots where name = $1`, name))
+		if errors.Is(err, ErrNotFound) {
+			return api.ErrNotExist
+		} else if err != nil {
+			return err
+		}
+		for _, cid := range chains {
+			var ids []uuid.UUID
+			ids, err = Drop(ctx, tx, cid)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, ids...)
+		}
+		return nil
+	})
+	return
+}
+
+func (r *Repository) Sparse(ctx context.Context, ratio float64, limit int) (packs []api.Pack, err error) {
+	err = r.db.SelectContext(ctx, &packs, `select id, size, live from packs where live < size * $1 order by live, id limit $2`,
+		ratio, limit)
+	return
+}
+
+func (r *Repository) Unpack(ctx context.Context, pid uuid.UUID) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, `select `+columns+` from blocks where kind = 2 and pack_id = $1 order by pack_offset`, pid)
+	return
+}
+
+func (r *Repository) Repack(ctx context.Context, pid1 uuid.UUID, pid2 uuid.UUID, blocks []uuid.UUID, offsets []int64) error {
+	return r.Transact(ctx, func(tx *sqlx.Tx) error {
+		var total int64
+		for i, id := range blocks {
+			var length int64
+			err := tx.GetContext(ctx, &length, `update blocks set pack_id = $1, pack_offset = $2
+				where id = $3 and kind = 2 and pack_id = $4 returning pack_length`, pid2, offsets[i], id, pid1)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			total += length
+		}
+		if total > 0 {
+			_, err := tx.ExecContext(ctx, `insert into packs (id, size, live, created) values ($1, $2, $2, $3)`,
+				pid2, total, time.Now().UnixNano())
+			if err != nil {
+				return err
+			}
+		}
+		var used bool
+		err := tx.GetContext(ctx, &used, `select exists(select 1 from blocks where pack_id = $1)`, pid1)
+		if err != nil {
+			return err
+		}
+		if used {
+			return errors.New("pack in use")
+		}
+		_, err = tx.ExecContext(ctx, `delete from packs where id = $1`, pid1)
+		return err
+	})
+}
+
+func (r *Repository) Exist(ctx context.Context, ids []uuid.UUID) (known []uuid.UUID, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	query, args, err := sqlx.In(`select id from blocks where kind = 0 and id in (?)
+		union select id from packs where id in (?)`, ids, ids)
+	if err != nil {
+		return
+	}
+	err = r.db.SelectContext(ctx, &known, r.db.Rebind(query), args...)
+	return
+}
+
+func (r *Repository) Check(ctx context.Context, bid uuid.UUID, corrupt bool) (err error) {
+	_, err = r.db.ExecContext(ctx, `update blocks set checked = $1, corrupt = $2 where id = $3`, time.Now().UnixNano(), corrupt, bid)
+	return
+}
+
+func (r *Repository) Affected(ctx context.Context, bid uuid.UUID) (names []string, err error) {
+	err = r.db.SelectContext(ctx, &names, `select distinct files.path from files
+		join links on links.chain_id = files.chain_id
+		where links.block_id = $1
+		order by files.path`, bid)
+	return
+}
+
+func (r *Repository) Touch(ctx context.Context, blocks []uuid.UUID) error {
+	return r.Transact(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now().UnixNano()
+		for _, id := range blocks {
+			_, err := tx.ExecContext(ctx, `update blocks set accessed = $1 where id = $2`, now, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) Stale(ctx context.Context, before time.Time, tier api.Tier, limit int) (blocks []api.Block, err error) {
+	err = r.db.SelectContext(ctx, &blocks, `select `+columns+` from blocks
+		where accessed < $1 and tier = $2 and kind = 0
+		order by accessed limit $3`, before.UnixNano(), tier, limit)
+	return
+}
+
+func (r *Repository) Move(ctx context.Context, bid uuid.UUID, from, to api.Tier) (bool, error) {
+	err := Affect(r.db.ExecContext(ctx, `update blocks set tier = $1 where id = $2 and tier = $3`, to, bid, from))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *Repository) Shutdown() {
+	if r == nil {
+		return
+	}
+	_ = r.db.Close()
+}
+
+// Affect turns an update of no rows into ErrNotFound.
+func Affect(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func New(u *url.URL) (*Repository, error) {
+	name := u.Host + u.Path
+	if name == "" {
+		name = ":memory:"
+	}
+	db, err := sqlx.Open("sqlite", name+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(wal)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	_, err = Migrate(context.Background(), db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Repository{
+		db: db,
+	}, nil
+}
@@ -0,0 +1,205 @@
+package sqlite
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+func TestSqlite(t *testing.T) {
+	ctx := context.TODO()
+	r, err := New(&url.URL{Scheme: "sqlite", Path: filepath.Join(t.TempDir(), "nocopy.db")})
+	require.NoError(t, err)
+	defer r.Shutdown()
+	b1 := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 3}
+	b2 := api.Block{ID: uuid.New(), Hash: api.Hash{2}, Size: 2, Kind: api.KindInline, Payload: []byte("ab")}
+	chains, err := r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Type: "text/plain",
+		Metadata: map[string]string{"k": "v"}, Blocks: []api.Block{b1, b2}}, uuid.Nil, []bool{false, false})
+	require.NoError(t, err)
+	require.Len(t, chains, 1)
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New()}, uuid.New(), nil)
+	require.ErrorIs(t, err, api.ErrPrecondition)
+	_, err = r.Update(ctx, api.Object{Key: "/c", Chain: uuid.New()}, api.Any, nil)
+	require.ErrorIs(t, err, api.ErrPrecondition)
+	object, err := r.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, chains[0], object.Chain)
+	require.Equal(t, api.ETag(chains[0]), object.ETag)
+	require.Equal(t, "text/plain", object.Type)
+	require.Equal(t, map[string]string{"k": "v"}, object.Metadata)
+	require.Equal(t, int64(5), object.Size)
+	require.Len(t, object.Blocks, 2)
+	require.Equal(t, api.Hash{1}, object.Blocks[0].Hash)
+	require.Equal(t, []byte("ab"), object.Blocks[1].Payload)
+	found, err := r.Lookup(ctx, api.AlgorithmSHA1, api.Hash{1}, 3)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, b1.ID, found[0].ID)
+	object, err = r.Get(ctx, "/b")
+	require.NoError(t, err)
+	require.Empty(t, object.Blocks)
+	b3 := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 3}
+	chains2, err := r.Update(ctx, api.Object{Key: "/b", Chain: uuid.New(), Blocks: []api.Block{b3}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+	require.NoError(t, r.Link(ctx, chains2[0], b3.ID, b1.ID))
+	b4 := api.Block{ID: uuid.New(), Hash: api.Hash{4}, Size: 1, Kind: api.KindPacked, Pack: uuid.New(), Length: 1}
+	_, err = r.Update(ctx, api.Object{Key: "/d", Chain: uuid.New(), Blocks: []api.Block{b4}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+	known, err := r.Exist(ctx, []uuid.UUID{b1.ID, b3.ID, b4.ID, b4.Pack, uuid.New()})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uuid.UUID{b1.ID, b4.Pack}, known)
+	known, err = r.Exist(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, known)
+	names, err := r.Affected(ctx, b1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/a", "/b"}, names)
+	require.ErrorIs(t, r.Link(ctx, chains2[0], b3.ID, b1.ID), ErrNotFound)
+	removed, ok, err := r.Delete(ctx, "/a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, removed)
+	removed, ok, err = r.Delete(ctx, "/b")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []uuid.UUID{b1.ID}, removed)
+	removed, ok, err = r.Delete(ctx, "/b")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Empty(t, removed)
+	_, err = r.Update(ctx, api.Object{Key: "/b", Chain: uuid.New(), Blocks: []api.Block{b1}}, uuid.Nil, []bool{true})
+	require.ErrorIs(t, err, ErrNotFound)
+	object, err = r.Get(ctx, "/b")
+	require.NoError(t, err)
+	require.Empty(t, object.Blocks)
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.TODO()
+	r, err := New(&url.URL{Scheme: "sqlite", Path: filepath.Join(t.TempDir(), "nocopy.db")})
+	require.NoError(t, err)
+	defer r.Shutdown()
+	var created time.Time
+	for i := 0; i < 2; i++ {
+		block := api.Block{ID: uuid.New(), Hash: api.Hash{byte(i)}, Size: 1, Kind: api.KindInline, Payload: []byte("a")}
+		_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{block}}, uuid.Nil, []bool{false})
+		require.NoError(t, err)
+		object, err := r.Get(ctx, "/a")
+		require.NoError(t, err)
+		require.Equal(t, i, object.Version)
+		if i == 0 {
+			created = object.Created
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		require.Equal(t, created, object.Created)
+		require.True(t, object.Modified.After(created))
+	}
+}
+
+func TestRename(t *testing.T) {
+	ctx := context.TODO()
+	r, err := New(&url.URL{Scheme: "sqlite", Path: filepath.Join(t.TempDir(), "nocopy.db")})
+	require.NoError(t, err)
+	defer r.Shutdown()
+	ids := map[string]uuid.UUID{}
+	for _, name := range []string{"/d/x", "/d/y", "/e/y"} {
+		ids[name] = uuid.New()
+		_, err = r.Update(ctx, api.Object{Key: name, Chain: uuid.New(), Blocks: []api.Block{{ID: ids[name], Hash: api.Hash{1}, Size: 1}}},
+			uuid.Nil, []bool{false})
+		require.NoError(t, err)
+	}
+	_, _, err = r.Rename(ctx, "/d/", "/e/", true, false)
+	require.ErrorIs(t, err, api.ErrExist)
+	object, err := r.Get(ctx, "/d/x")
+	require.NoError(t, err)
+	require.Len(t, object.Blocks, 1)
+	removed, replaced, err := r.Rename(ctx, "/d/", "/e/", true, true)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, []uuid.UUID{ids["/e/y"]}, removed)
+	for name, id := range map[string]uuid.UUID{"/e/x": ids["/d/x"], "/e/y": ids["/d/y"]} {
+		object, err = r.Get(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, id, object.Blocks[0].ID)
+	}
+	_, _, err = r.Rename(ctx, "/d/x", "/f", false, false)
+	require.ErrorIs(t, err, api.ErrNotExist)
+	removed, replaced, err = r.Rename(ctx, "/e/x", "/f", false, false)
+	require.NoError(t, err)
+	require.False(t, replaced)
+	require.Empty(t, removed)
+	object, err = r.Get(ctx, "/f")
+	require.NoError(t, err)
+	require.Equal(t, ids["/d/x"], object.Blocks[0].ID)
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.TODO()
+	r, err := New(&url.URL{Scheme: "sqlite", Path: filepath.Join(t.TempDir(), "nocopy.db")})
+	require.NoError(t, err)
+	defer r.Shutdown()
+	b1 := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 1}
+	b2 := api.Block{ID: uuid.New(), Hash: api.Hash{2}, Size: 1}
+	_, err = r.Update(ctx, api.Object{Key: "/s/a", Chain: uuid.New(), Type: "text/plain", Blocks: []api.Block{b1}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+	_, err = r.Snapshot(ctx, "x", "/t/")
+	require.ErrorIs(t, err, api.ErrNotExist)
+	snapshot, err := r.Snapshot(ctx, "x", "/s/")
+	require.NoError(t, err)
+	require.Equal(t, 1, snapshot.Files)
+	_, err = r.Snapshot(ctx, "x", "/s/")
+	require.ErrorIs(t, err, api.ErrExist)
+	snapshots, err := r.Snapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, "/s/", snapshots[0].Prefix)
+	require.Equal(t, 1, snapshots[0].Files)
+	chains, err := r.Update(ctx, api.Object{Key: "/s/a", Chain: uuid.New(), Blocks: []api.Block{b2}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+	removed, err := r.Break(ctx, chains[1])
+	require.NoError(t, err)
+	require.Empty(t, removed)
+	object, err := r.View(ctx, "x", "/s/a")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", object.Type)
+	require.Equal(t, b1.ID, object.Blocks[0].ID)
+	_, err = r.View(ctx, "y", "/s/a")
+	require.ErrorIs(t, err, api.ErrNotExist)
+	removed, err = r.Restore(ctx, "x")
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{b2.ID}, removed)
+	object, err = r.Get(ctx, "/s/a")
+	require.NoError(t, err)
+	require.Equal(t, 2, object.Version)
+	require.Equal(t, b1.ID, object.Blocks[0].ID)
+	_, _, err = r.Rename(ctx, "/s/a", "/s/b", false, false)
+	require.NoError(t, err)
+	removed, err = r.Restore(ctx, "x")
+	require.NoError(t, err)
+	require.Empty(t, removed)
+	for _, name := range []string{"/s/a", "/s/b"} {
+		object, err = r.Get(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, b1.ID, object.Blocks[0].ID)
+	}
+	removed, err = r.Release(ctx, "x")
+	require.NoError(t, err)
+	require.Empty(t, removed)
+	_, err = r.Release(ctx, "x")
+	require.ErrorIs(t, err, api.ErrNotExist)
+	removed, ok, err := r.Delete(ctx, "/s/a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, removed)
+	removed, _, err = r.Delete(ctx, "/s/b")
+	require.NoError(t, err)
+	require.Equal(t, []uuid.UUID{b1.ID}, removed)
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +16,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/pshvedko/nocopy/api"
 	"github.com/pshvedko/nocopy/broker"
 	"github.com/pshvedko/nocopy/internal/log"
 	"github.com/pshvedko/nocopy/repository"
@@ -28,7 +32,29 @@ type Block struct {
 	repository.Repository
 	atomic.Bool
 	atomic.Uint64
-	Size int64
+	Size      int64
+	Min       int64
+	Max       int64
+	Chunk     string
+	Compress  string
+	Hash      string
+	Algorithm api.Algorithm
+	Inline    bool
+	Small     int64
+	Pack      int64
+	Linger    time.Duration
+	packer    Packer
+	Trust     bool
+	Ahead     int
+	Parallel  int
+	Cache     string
+	Metrics   string
+	Cold      string
+	Frozen    storage.Storage
+	Promote   bool
+	Touch     time.Duration
+	touched   sync.Map
+	warming   sync.Map
 }
 
 func (s *Block) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -45,6 +71,10 @@ func (s *Block) Run(ctx context.Context, addr, port, base, file, pipe string, si
 	if err != nil {
 		return err
 	}
+	s.Algorithm, err = api.ParseAlgorithm(s.Hash)
+	if err != nil {
+		return err
+	}
 	s.Broker, err = broker.New(pipe, path.Join("block", host, "1"))
 	if err != nil {
 		return err
@@ -62,6 +92,31 @@ func (s *Block) Run(ctx context.Context, addr, port, base, file, pipe string, si
 		return err
 	}
 	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	if len(s.Cache) > 0 {
+		c, err := storage.NewCache(s.Storage, s.Cache)
+		if err != nil {
+			return err
+		}
+		expvar.Publish("cache", expvar.Func(c.Metrics))
+		s.Storage = c
+	}
+	if len(s.Metrics) > 0 {
+		m := http.Server{Addr: s.Metrics, Handler: expvar.Handler()}
+		defer m.Close()
+		go func() {
+			err := m.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("metrics", "err", err)
+			}
+		}()
+	}
 	s.Repository, err = repository.New(base)
 	if err != nil {
 		return err
@@ -71,10 +126,12 @@ func (s *Block) Run(ctx context.Context, addr, port, base, file, pipe string, si
 	h.Use(middleware.Logger)
 	h.Use(middleware.Recoverer)
 	h.Use(middleware.SetHeader("Server", "NoCopy"))
+	h.Use(ReadOnly)
 	h.Put("/*", s.Put)
 	h.Get("/*", s.Get)
 	h.Delete("/*", s.Delete)
 	h.Head("/*", s.Head)
+	h.Method("MOVE", "/*", http.HandlerFunc(s.Move))
 	s.Handler = h
 	s.Server.Handler = s
 	s.Size = size
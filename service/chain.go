@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"sync/atomic"
+	"time"
 
 	"github.com/pshvedko/nocopy/broker"
 	"github.com/pshvedko/nocopy/internal/log"
@@ -17,6 +18,11 @@ type Chain struct {
 	storage.Storage
 	repository.Repository
 	atomic.Bool
+	Trust  bool
+	Scrub  time.Duration
+	Batch  int
+	Cold   string
+	Frozen storage.Storage
 }
 
 func (s *Chain) Run(ctx context.Context, base, file, pipe string) error {
@@ -36,20 +42,30 @@ func (s *Chain) Run(ctx context.Context, base, file, pipe string) error {
 	s.Broker.Handle("head", s.HeadQuery)
 	s.Broker.UseMiddleware(Authorize{})
 	s.Broker.UseTransport(log.Transport{Transport: s.Broker.Transport()})
-	err = s.Broker.Listen(ctx, "chain", host, "1")
-	if err != nil {
-		return err
-	}
 	s.Storage, err = storage.New(file)
 	if err != nil {
 		return err
 	}
 	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
 	s.Repository, err = repository.New(base)
 	if err != nil {
 		return err
 	}
 	defer s.Repository.Shutdown()
+	err = s.Broker.Listen(ctx, "chain", host, "1")
+	if err != nil {
+		return err
+	}
+	if s.Scrub > 0 {
+		go s.Scrubber(ctx)
+	}
 	<-ctx.Done()
 	s.Broker.Finish()
 	return nil
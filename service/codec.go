@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal/io"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+var ErrCodec = errors.New("invalid codec")
+
+func (s *Block) Encode(b []byte) ([]byte, api.Codec, error) {
+	switch s.Compress {
+	case "none", "":
+		return b, api.CodecNone, nil
+	case "zstd":
+		z := io.Compress(b, io.FrameSize)
+		if len(z) < len(b) {
+			return z, api.CodecZstd, nil
+		}
+		return b, api.CodecNone, nil
+	default:
+		return nil, 0, ErrCodec
+	}
+}
+
+// Open reads a block from its tier, or from the other one.
+func Open(ctx context.Context, hot, cold storage.Storage, block api.Block) (io.ReadSeekCloser, error) {
+	if cold == nil {
+		r, err := hot.Load(ctx, Name(block))
+		if err != nil {
+			return nil, err
+		}
+		return Decode(r, block)
+	}
+	tiers := []storage.Storage{hot, cold}
+	if block.Tier == api.TierCold {
+		tiers[0], tiers[1] = cold, hot
+	}
+	var err error
+	for _, t := range tiers {
+		var r io.ReadSeekCloser
+		r, err = Probe(ctx, t, Name(block))
+		if err == nil {
+			return Decode(r, block)
+		}
+	}
+	return nil, err
+}
+
+func OpenAt(ctx context.Context, hot, cold storage.Storage, block api.Block, offset int64) (io.ReadCloser, error) {
+	r, err := Open(ctx, hot, cold, block)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Range reads a part of an uncompressed block with a ranged request.
+func Range(ctx context.Context, hot, cold storage.Storage, block api.Block, offset, length int64) (io.ReadCloser, error) {
+	if block.Kind == api.KindPacked {
+		offset += block.Offset
+	}
+	if cold == nil {
+		return hot.LoadRange(ctx, Name(block), offset, length)
+	}
+	tiers := []storage.Storage{hot, cold}
+	if block.Tier == api.TierCold {
+		tiers[0], tiers[1] = cold, hot
+	}
+	var err error
+	for _, t := range tiers {
+		_, err = t.Stat(ctx, Name(block))
+		if err == nil {
+			return t.LoadRange(ctx, Name(block), offset, length)
+		}
+	}
+	return nil, err
+}
+
+func Name(block api.Block) string {
+	if block.Kind == api.KindPacked {
+		return block.Pack.String()
+	}
+	return block.ID.String()
+}
+
+// Probe loads an object and checks that it can be read.
+func Probe(ctx context.Context, s storage.Storage, name string) (io.ReadSeekCloser, error) {
+	r, err := s.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = r.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func Decode(r io.ReadSeekCloser, block api.Block) (io.ReadSeekCloser, error) {
+	if block.Kind == api.KindPacked {
+		p, err := io.NewSection(r, block.Offset, block.Length)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r = p
+	}
+	switch block.Codec {
+	case api.CodecNone:
+		return r, nil
+	case api.CodecZstd:
+		d, err := io.Decompressor(r)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		return d, nil
+	default:
+		_ = r.Close()
+		return nil, ErrCodec
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func (s *Chain) Collect(ctx context.Context, base, file string, grace time.Duration, batch int, dry bool) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	var seen, orphans, failed int
+	var ids []uuid.UUID
+	deadline := time.Now().Add(-grace)
+	sweep := func(store storage.Storage) error {
+		known, err := s.Repository.Exist(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if slices.Contains(known, id) {
+				continue
+			}
+			orphans++
+			l := slog.With("object", id, "dry", dry)
+			if dry {
+				l.Info("collect")
+				continue
+			}
+			err = store.Purge(ctx, id.String())
+			if err != nil {
+				l.Error("collect", "err", err)
+				failed++
+				continue
+			}
+			l.Info("collect")
+		}
+		ids = ids[:0]
+		return nil
+	}
+	stores := []storage.Storage{s.Storage}
+	if s.Frozen != nil {
+		stores = append(stores, s.Frozen)
+	}
+	for _, store := range stores {
+		var after string
+		for {
+			infos, err := store.List(ctx, after, batch)
+			if err != nil {
+				return err
+			}
+			if len(infos) == 0 {
+				break
+			}
+			after = infos[len(infos)-1].Name
+			for _, info := range infos {
+				seen++
+				id, err := uuid.Parse(info.Name)
+				if err != nil {
+					slog.Warn("collect", "name", info.Name, "err", err)
+					continue
+				}
+				if info.Time.After(deadline) {
+					continue
+				}
+				ids = append(ids, id)
+			}
+			err = sweep(store)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	slog.Info("collect", "seen", seen, "orphans", orphans, "failed", failed, "dry", dry)
+	return nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestCollect(t *testing.T) {
+	const base, file = "mem://collect", "mem://collect"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4}
+	packed := api.Block{ID: uuid.New(), Hash: api.Hash{2}, Size: 4, Kind: api.KindPacked, Pack: uuid.New(), Length: 4}
+	orphan := uuid.New()
+	for _, name := range []string{block.ID.String(), packed.Pack.String(), orphan.String(), "readme"} {
+		_, err = s.Store(ctx, name, 4, bytes.NewBufferString("aaaa"))
+		require.NoError(t, err)
+	}
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{block, packed}}, uuid.Nil, []bool{false, false})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	fresh := uuid.New()
+	_, err = s.Store(ctx, fresh.String(), 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+
+	require.NoError(t, (&Chain{}).Collect(ctx, base, file, 25*time.Millisecond, 2, true))
+	require.Equal(t, 5, Count(t, s))
+	require.NoError(t, (&Chain{}).Collect(ctx, base, file, 25*time.Millisecond, 2, false))
+	require.Equal(t, 4, Count(t, s))
+	for _, name := range []string{block.ID.String(), packed.Pack.String(), fresh.String(), "readme"} {
+		_, err = s.Stat(ctx, name)
+		require.NoError(t, err)
+	}
+	_, err = s.Stat(ctx, orphan.String())
+	require.Error(t, err)
+
+	c, err := storage.New("mem://collect-cold")
+	require.NoError(t, err)
+	for _, name := range []string{block.ID.String(), orphan.String()} {
+		_, err = c.Store(ctx, name, 4, bytes.NewBufferString("aaaa"))
+		require.NoError(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, (&Chain{Cold: "mem://collect-cold"}).Collect(ctx, base, file, 25*time.Millisecond, 2, false))
+	require.Equal(t, 1, Count(t, c))
+	_, err = c.Stat(ctx, block.ID.String())
+	require.NoError(t, err)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal/io"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+// Compact rewrites sparse packs, the old packs are purged after the delay as readers may have just looked them up. If
+// the job is stopped before, they are left to the collector.
+func (s *Chain) Compact(ctx context.Context, base, file string, ratio float64, batch int, delay time.Duration) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	var done, failed int
+	var skip = map[uuid.UUID]bool{}
+	var old []uuid.UUID
+	for ctx.Err() == nil {
+		packs, err := s.Repository.Sparse(ctx, ratio, batch+len(skip))
+		if err != nil {
+			return err
+		}
+		var n int
+		for _, pack := range packs {
+			if skip[pack.ID] {
+				continue
+			}
+			n++
+			l := slog.With("pack", pack.ID, "size", pack.Size, "live", pack.Live)
+			err = s.Rewrite(ctx, pack)
+			if err != nil {
+				l.Error("compact", "err", err)
+				skip[pack.ID] = true
+				failed++
+				continue
+			}
+			l.Info("compact")
+			old = append(old, pack.ID)
+			done++
+		}
+		if n == 0 {
+			break
+		}
+	}
+	slog.Info("compact", "ratio", ratio, "done", done, "failed", failed)
+	if len(old) == 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+	}
+	for _, id := range old {
+		err := Purge(ctx, s.Storage, s.Frozen, id.String())
+		if err != nil {
+			slog.Error("compact", "pack", id, "err", err)
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Chain) Rewrite(ctx context.Context, pack api.Pack) error {
+	blocks, err := s.Repository.Unpack(ctx, pack.ID)
+	if err != nil {
+		return err
+	}
+	id := uuid.Nil
+	ids := make([]uuid.UUID, len(blocks))
+	offsets := make([]int64, len(blocks))
+	if len(blocks) > 0 {
+		id = uuid.New()
+		var buf bytes.Buffer
+		err = s.Copy(ctx, pack.ID, blocks, &buf)
+		if err != nil {
+			return err
+		}
+		var offset int64
+		for i, block := range blocks {
+			ids[i], offsets[i] = block.ID, offset
+			offset += block.Length
+		}
+		_, err = s.Storage.Store(ctx, id.String(), int64(buf.Len()), &buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.Repository.Repack(ctx, pack.ID, id, ids, offsets)
+	if err != nil && id != uuid.Nil {
+		_ = s.Storage.Purge(ctx, id.String())
+	}
+	return err
+}
+
+func (s *Chain) Copy(ctx context.Context, id uuid.UUID, blocks []api.Block, w io.Writer) error {
+	r, err := Open(ctx, s.Storage, s.Frozen, api.Block{ID: id})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	for _, block := range blocks {
+		_, err = r.Seek(block.Offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyRange(w, r, 0, block.Length)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestCompact(t *testing.T) {
+	const base, file = "mem://compact", "mem://compact"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	sparse := func() uuid.UUID {
+		pack := uuid.New()
+		_, err := s.Store(ctx, pack.String(), 8, bytes.NewBufferString("aaaabbbb"))
+		require.NoError(t, err)
+		for i, key := range []string{"/a", "/b"} {
+			block := api.Block{ID: uuid.New(), Hash: api.Hash{byte(i)}, Size: 4, Kind: api.KindPacked, Pack: pack,
+				Offset: int64(4 * i), Length: 4}
+			_, err = r.Update(ctx, api.Object{Key: pack.String() + key, Chain: uuid.New(), Blocks: []api.Block{block}},
+				uuid.Nil, []bool{false})
+			require.NoError(t, err)
+		}
+		_, found, err := r.Delete(ctx, pack.String()+"/b")
+		require.NoError(t, err)
+		require.True(t, found)
+		return pack
+	}
+
+	old := sparse()
+	ctx2, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- (&Chain{}).Compact(ctx2, base, file, 0.75, 10, time.Hour) }()
+	require.Eventually(t, func() bool {
+		blocks, err := r.Unpack(ctx, old)
+		return err == nil && len(blocks) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err = s.Stat(ctx, old.String())
+	require.NoError(t, err)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	_, err = s.Stat(ctx, old.String())
+	require.NoError(t, err)
+
+	pack := sparse()
+	require.NoError(t, (&Chain{}).Compact(ctx, base, file, 0.75, 10, time.Millisecond))
+	_, err = s.Stat(ctx, pack.String())
+	require.Error(t, err)
+	_, err = s.Stat(ctx, old.String())
+	require.NoError(t, err)
+	object, err := r.Get(ctx, pack.String()+"/a")
+	require.NoError(t, err)
+	require.Len(t, object.Blocks, 1)
+	require.NotEqual(t, pack, object.Blocks[0].Pack)
+	b, err := s.LoadRange(ctx, object.Blocks[0].Pack.String(), object.Blocks[0].Offset, object.Blocks[0].Length)
+	require.NoError(t, err)
+	defer func() {
+		_ = b.Close()
+	}()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(b)
+	require.NoError(t, err)
+	require.Equal(t, "aaaa", buf.String())
+}
@@ -4,24 +4,28 @@ import (
 	"log/slog"
 	"net/http"
 	"path"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/pshvedko/nocopy/api"
 )
 
 func (s *Block) Delete(w http.ResponseWriter, r *http.Request) {
-	blocks, err := s.Repository.Delete(r.Context(), path.Clean(r.URL.Path))
+	name := path.Clean(r.URL.Path)
+	blocks, found, err := s.Repository.Delete(r.Context(), name)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-	} else if len(blocks) == 0 {
+	} else if !found {
 		w.WriteHeader(http.StatusNotFound)
 	} else {
 		w.WriteHeader(http.StatusNoContent)
+		Notify(r.Context(), s.Broker, api.Event{
+			Type: api.EventDeleted,
+			Name: name,
+			Time: time.Now(),
+		})
 		for _, id := range blocks {
-			if id == uuid.Nil {
-				continue
-			}
 			slog.Info("delete", "id", id)
-			err = s.Storage.Purge(r.Context(), id.String())
+			err = Purge(r.Context(), s.Storage, s.Frozen, id.String())
 			if err != nil {
 				slog.Error("delete", "err", err)
 			}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/broker"
+	"github.com/pshvedko/nocopy/broker/message"
+	"github.com/pshvedko/nocopy/internal/log"
+)
+
+// Notify broadcasts the event to every service listening to events.
+func Notify(ctx context.Context, b broker.Broker, event api.Event) {
+	_, from := b.Topic(0)
+	_, err := b.Send(ctx, message.New().
+		WithType(message.Broadcast).
+		WithTo("event").
+		WithFrom(from).
+		WithMethod("event").
+		WithBody(message.NewBody(event)).
+		Build())
+	if err != nil {
+		slog.Error("event", "type", event.Type, "name", event.Name, "err", err)
+	}
+}
+
+type Webhook struct {
+	http.Client
+	URL    string
+	Secret string
+	Retry  int
+	Delay  time.Duration
+}
+
+func (h *Webhook) Sign(body []byte) string {
+	m := hmac.New(sha256.New, []byte(h.Secret))
+	_, _ = m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum([]byte{}))
+}
+
+func (h *Webhook) Post(ctx context.Context, id string, event api.Event) (bool, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-NoCopy-Event", string(event.Type))
+	r.Header.Set("X-NoCopy-Delivery", id)
+	if len(h.Secret) > 0 {
+		r.Header.Set("X-NoCopy-Signature", h.Sign(body))
+	}
+	w, err := h.Client.Do(r)
+	if err != nil {
+		return true, err
+	}
+	_ = w.Body.Close()
+	switch {
+	case w.StatusCode < 300:
+		return false, nil
+	case w.StatusCode == http.StatusTooManyRequests, w.StatusCode >= 500:
+		return true, fmt.Errorf("webhook: %s", w.Status)
+	default:
+		return false, fmt.Errorf("webhook: %s", w.Status)
+	}
+}
+
+func (h *Webhook) Deliver(ctx context.Context, id string, event api.Event) (err error) {
+	delay := h.Delay
+	for i := 0; ; i++ {
+		var retry bool
+		retry, err = h.Post(ctx, id, event)
+		if !retry || i >= h.Retry {
+			return
+		}
+		slog.Warn("event", "id", id, "retry", i+1, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+func (s *Proxy) Hook(ctx context.Context, target, secret string, retry int, delay, timeout time.Duration, pipe string) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s.Broker, err = broker.New(pipe, path.Join("hook", host, "1"))
+	if err != nil {
+		return err
+	}
+	defer s.Broker.Shutdown()
+	s.Webhook = &Webhook{
+		Client: http.Client{Timeout: timeout},
+		URL:    target,
+		Secret: secret,
+		Retry:  retry,
+		Delay:  delay,
+	}
+	s.Broker.Handle("event", s.EventQuery)
+	s.Broker.UseMiddleware(Authorize{})
+	s.Broker.UseTransport(log.Transport{Transport: s.Broker.Transport()})
+	slog.Info("event", "url", target, "retry", retry)
+	err = s.Broker.Listen(ctx, "hook", host, "1")
+	if err != nil {
+		return err
+	}
+	// running hooks share the group, so each event is posted once
+	err = s.Broker.Group(ctx, "event", "hook")
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	s.Broker.Finish()
+	return nil
+}
+
+func (s *Proxy) EventQuery(ctx context.Context, m message.Message) (message.Body, error) {
+	var event api.Event
+	err := m.Decode(&event)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("event", "type", event.Type, "name", event.Name)
+	err = s.Webhook.Deliver(ctx, m.ID().String(), event)
+	if err != nil {
+		slog.Error("event", "id", m.ID(), "err", err)
+	}
+	return nil, nil
+}
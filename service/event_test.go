@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/broker"
+	"github.com/pshvedko/nocopy/broker/message"
+)
+
+func TestHook(t *testing.T) {
+	const pipe = "mem://hook"
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	var mu sync.Mutex
+	var events []api.Event
+	h := &Webhook{Secret: "secret"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event api.Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		body, err := json.Marshal(event)
+		require.NoError(t, err)
+		require.Equal(t, h.Sign(body), r.Header.Get("X-NoCopy-Signature"))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		p := &Proxy{}
+		go func() { _ = p.Hook(ctx, srv.URL, h.Secret, 0, time.Millisecond, time.Second, pipe) }()
+	}
+	var seen atomic.Int32
+	l, err := broker.New(pipe, "audit/test/1")
+	require.NoError(t, err)
+	defer l.Shutdown()
+	l.Handle("event", func(context.Context, message.Message) (message.Body, error) {
+		seen.Add(1)
+		return nil, nil
+	})
+	l.UseMiddleware(Authorize{})
+	require.NoError(t, l.Listen(ctx, "event", "test", "1"))
+	b, err := broker.New(pipe, "block/test/1")
+	require.NoError(t, err)
+	defer b.Shutdown()
+	b.UseMiddleware(Authorize{})
+	require.NoError(t, b.Listen(ctx, "block", "test", "1"))
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events)
+	}
+	require.Eventually(t, func() bool {
+		Notify(ctx, b, api.Event{Type: api.EventCreated, Name: "/ready"})
+		return count() > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	n, m := count(), int(seen.Load())
+	for i := 0; i < 10; i++ {
+		Notify(ctx, b, api.Event{Type: api.EventCreated, Name: "/a"})
+	}
+	require.Eventually(t, func() bool { return count() >= n+10 }, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, n+10, count())
+	require.Eventually(t, func() bool { return int(seen.Load()) == m+10 }, 10*time.Second, 10*time.Millisecond)
+}
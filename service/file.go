@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -39,41 +40,73 @@ func (s *Chain) FileQuery(ctx context.Context, m message.Message) (message.Body,
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("file", "chains", file.Chains, "blocks", file.Blocks, "hashes", file.Hashes, "sizes", file.Sizes)
-	err = s.File(ctx, file.Chains, file.Blocks, file.Hashes, file.Sizes)
+	slog.Info("file", "name", file.Name, "chains", file.Chains, "blocks", file.Blocks, "hashes", file.Hashes, "sizes", file.Sizes, "algorithm", file.Algorithm)
+	err = s.File(ctx, file)
 	if err != nil {
 		return nil, err
 	}
 	return message.NewBody(api.FileReply{Time: time.Now()}), nil
 }
 
-func (s *Chain) File(ctx context.Context, chains []uuid.UUID, blocks []uuid.UUID, hashes []api.Hash, sizes []int64) error {
+func (s *Chain) File(ctx context.Context, file api.File) error {
+	name, chains, blocks, hashes, sizes := file.Name, file.Chains, file.Blocks, file.Hashes, file.Sizes
+	var linked []uuid.UUID
 	for i := range blocks {
-		similarities, err := s.Repository.Lookup(ctx, hashes[i], sizes[i])
+		similarities, err := s.Repository.Lookup(ctx, file.Algorithm, hashes[i], sizes[i])
 		if err != nil {
 			slog.Error("file", "err", err)
 			continue
 		}
-		if len(similarities) == 0 || similarities[0] == blocks[i] {
+		k := slices.IndexFunc(similarities, func(b api.Block) bool { return b.ID == blocks[i] })
+		if k < 1 {
+			continue
+		}
+		purge := func() {
+			if similarities[k].Kind != api.KindStorage {
+				return
+			}
+			l := slog.With("block", blocks[i])
+			// a snapshot taken before the deduplication still refers to the block
+			known, err := s.Repository.Exist(ctx, blocks[i:i+1])
+			if err != nil {
+				l.Error("file", "err", err)
+				return
+			} else if len(known) > 0 {
+				l.Info("file", "pinned", true)
+				return
+			}
+			err = s.Storage.Purge(ctx, blocks[i].String())
+			if err != nil {
+				l.Error("file", "err", err)
+			} else {
+				l.Info("file")
+			}
+		}
+		if s.Trust && file.Algorithm.Strong() {
+			slog.Info("file", "blocks", []uuid.UUID{blocks[i], similarities[0].ID}, "trust", true)
+			err = s.Repository.Link(ctx, chains[0], blocks[i], similarities[0].ID)
+			if err != nil {
+				slog.Error("file", "chain", chains[0], "blocks", []uuid.UUID{blocks[i], similarities[0].ID}, "err", err)
+				continue
+			}
+			linked = append(linked, similarities[0].ID)
+			purge()
 			continue
 		}
 		var origin io.ReadSeekCloser
-		origin, err = s.Storage.Load(ctx, blocks[i].String())
+		origin, err = Open(ctx, s.Storage, s.Frozen, similarities[k])
 		if err != nil {
 			slog.Error("file", "name", blocks[i], "err", err)
 			continue
 		}
-		slog.Info("file", "similarities", similarities, "i", i)
+		slog.Info("file", "similarities", len(similarities), "i", i)
 		if func() bool {
 			var n int
-			for j := range similarities {
-				if similarities[j] == blocks[i] {
-					return true
-				}
+			for j := range similarities[:k] {
 				var similar io.ReadCloser
-				similar, err = s.Storage.Load(ctx, similarities[j].String())
+				similar, err = Open(ctx, s.Storage, s.Frozen, similarities[j])
 				if err != nil {
-					slog.Error("file", "id", similarities[j], "err", err)
+					slog.Error("file", "id", similarities[j].ID, "err", err)
 					continue
 				}
 				if n > 0 {
@@ -85,21 +118,16 @@ func (s *Chain) File(ctx context.Context, chains []uuid.UUID, blocks []uuid.UUID
 				if err != nil {
 					slog.Error("file", "j", j, "err", err)
 				} else if ok {
-					slog.Info("file", "blocks", []uuid.UUID{blocks[i], similarities[j]})
-					err = s.Repository.Link(ctx, chains[0], blocks[i], similarities[j])
+					slog.Info("file", "blocks", []uuid.UUID{blocks[i], similarities[j].ID})
+					err = s.Repository.Link(ctx, chains[0], blocks[i], similarities[j].ID)
 					if err == nil {
-						l := slog.With("block", blocks[i])
+						linked = append(linked, similarities[j].ID)
 						_ = origin.Close()
 						_ = similar.Close()
-						err = s.Storage.Purge(ctx, blocks[i].String())
-						if err != nil {
-							l.Error("file", "err", err)
-						} else {
-							l.Info("file")
-						}
+						purge()
 						return false
 					}
-					slog.Error("file", "chain", chains[0], "blocks", []uuid.UUID{blocks[i], similarities[j]}, "err", err)
+					slog.Error("file", "chain", chains[0], "blocks", []uuid.UUID{blocks[i], similarities[j].ID}, "err", err)
 				}
 				_ = similar.Close()
 				n++
@@ -109,6 +137,15 @@ func (s *Chain) File(ctx context.Context, chains []uuid.UUID, blocks []uuid.UUID
 			_ = origin.Close()
 		}
 	}
+	if len(linked) > 0 {
+		Notify(ctx, s.Broker, api.Event{
+			Type:   api.EventDeduplicated,
+			Name:   name,
+			Time:   time.Now(),
+			Chain:  chains[0],
+			Blocks: linked,
+		})
+	}
 	if len(chains) == 1 {
 		return nil
 	}
@@ -119,7 +156,7 @@ func (s *Chain) File(ctx context.Context, chains []uuid.UUID, blocks []uuid.UUID
 	}
 	for i := range oldies {
 		l := slog.With("block", oldies[i], "old", true)
-		err = s.Storage.Purge(ctx, oldies[i].String())
+		err = Purge(ctx, s.Storage, s.Frozen, oldies[i].String())
 		if err != nil {
 			l.Error("file", "err", err)
 		} else {
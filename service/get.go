@@ -1,36 +1,48 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"path"
 	"strconv"
-	"time"
-
-	"github.com/google/uuid"
 
+	"github.com/pshvedko/nocopy/api"
 	"github.com/pshvedko/nocopy/internal/io"
 	"github.com/pshvedko/nocopy/internal/multipart"
 )
 
+// Object reads the file of a request, from the snapshot of the query if there is one.
+func (s *Block) Object(r *http.Request) (api.Object, error) {
+	name := path.Clean(r.URL.Path)
+	if snapshot := r.URL.Query().Get(SnapshotQuery); len(snapshot) > 0 {
+		return s.Repository.View(r.Context(), snapshot, name)
+	}
+	return s.Repository.Get(r.Context(), name)
+}
+
 func (s *Block) Get(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var ranges []multipart.Range
-	var blocks []uuid.UUID
-	var sizes []int64
-	var size int64
-	var date time.Time
-	var mime string
-	if mime, date, size, blocks, sizes, err = s.Repository.Get(r.Context(), path.Clean(r.URL.Path)); err != nil {
+	var object api.Object
+	if object, err = s.Object(r); errors.Is(err, api.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-	} else if len(blocks) == 0 {
+	} else if len(object.Blocks) == 0 {
 		w.WriteHeader(http.StatusNotFound)
 		return
-	} else if ranges, err = multipart.ParseRange(r.Header.Get("Range"), size); err != nil {
+	} else if ranges, err = multipart.ParseRange(r.Header.Get("Range"), object.Size); err != nil {
 		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 	} else {
 		slog.Info("get", "range", ranges)
+		list, size, mime := object.Blocks, object.Size, object.Type
+		s.Access(r.Context(), list)
+		blocks, sizes := api.Split(list)
 		var part []string
 		var status int
 		var length int64
@@ -69,7 +81,7 @@ func (s *Block) Get(w http.ResponseWriter, r *http.Request) {
 			mime = "multipart/byte" + "ranges; boundary=" + part[0]
 		}
 		w.Header().Add("Content-Length", strconv.FormatInt(length, 10))
-		w.Header().Add("Last-Modified", date.Format(time.RFC1123))
+		Describe(w.Header(), object)
 		if len(mime) > 0 {
 			w.Header().Add("Content-Type", mime)
 		}
@@ -78,32 +90,23 @@ func (s *Block) Get(w http.ResponseWriter, r *http.Request) {
 		slog.Info("get", "offsets", offsets)
 		slog.Info("get", "lengths", lengths)
 		var m int64
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		fetches := s.Prefetch(ctx, list, offsets, lengths)
 		for i, id := range blocks {
 			err = func() (err error) {
 				if len(offsets[i]) == 0 {
 					return
 				}
-				body, err := s.Storage.Load(r.Context(), id.String())
-				if err != nil {
-					return
+				q, ok := <-fetches
+				if !ok {
+					return ctx.Err()
 				}
-				defer func(c io.Closer) {
-					e := c.Close()
-					if err == nil {
-						err = e
-					}
-				}(body)
-				//body, err = io.Decompressor(body)
-				//if err != nil {
-				//	return
-				//}
-				//defer func(c io.Closer) {
-				//	e := c.Close()
-				//	if err == nil {
-				//		err = e
-				//	}
-				//}(body)
-				var z int64
+				f := <-q
+				if f.err != nil {
+					return f.err
+				}
+				start, _ := span(offsets[i], lengths[i])
 				for j := range offsets[i] {
 					if len(part) == 2 && len(ranges) > 0 && m == 0 {
 						err = multipart.WriteHeader(w, ranges[0].Start, ranges[0].Length, size, part[0], part[1])
@@ -113,14 +116,14 @@ func (s *Block) Get(w http.ResponseWriter, r *http.Request) {
 						m = ranges[0].Length
 						ranges = ranges[1:]
 					}
-					slog.Info("get", "id", id, "offset", offsets[i][j]-z, "length", lengths[i][j])
-					var n int64
-					n, err = io.CopyRange(w, body, offsets[i][j]-z, lengths[i][j])
+					o := offsets[i][j] - start
+					slog.Info("get", "id", id, "offset", o, "length", lengths[i][j])
+					var n int
+					n, err = w.Write(f.b[o : o+lengths[i][j]])
 					if err != nil {
 						return
 					}
-					z += n + offsets[i][j] - z
-					m -= n
+					m -= int64(n)
 				}
 				return
 			}()
@@ -139,3 +142,74 @@ func (s *Block) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	slog.Error("get", "err", err)
 }
+
+type Fetch struct {
+	b   []byte
+	err error
+}
+
+// span returns the lowest start and the highest end of the ranges.
+func span(offsets, lengths []int64) (start, end int64) {
+	start, end = offsets[0], offsets[0]+lengths[0]
+	for i := range offsets {
+		start = min(start, offsets[i])
+		end = max(end, offsets[i]+lengths[i])
+	}
+	return
+}
+
+// Fetch reads the part of the block from the start to the end of its ranges.
+func (s *Block) Fetch(ctx context.Context, block api.Block, offsets, lengths []int64) (b []byte, err error) {
+	start, end := span(offsets, lengths)
+	if block.Kind == api.KindInline {
+		if end > int64(len(block.Payload)) {
+			return nil, io.EOF
+		}
+		return block.Payload[start:end], nil
+	}
+	var body io.ReadCloser
+	length := end - start
+	if block.Codec == api.CodecNone {
+		body, err = Range(ctx, s.Storage, s.Frozen, block, start, length)
+	} else {
+		body, err = OpenAt(ctx, s.Storage, s.Frozen, block, start)
+	}
+	if err != nil {
+		return
+	}
+	defer func(c io.Closer) {
+		e := c.Close()
+		if err == nil {
+			err = e
+		}
+	}(body)
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, body, length)
+	if err == nil {
+		s.Warm(ctx, block)
+	}
+	return buf.Bytes(), err
+}
+
+func (s *Block) Prefetch(ctx context.Context, blocks []api.Block, offsets, lengths [][]int64) <-chan chan Fetch {
+	fetches := make(chan chan Fetch, max(s.Ahead, 0))
+	go func() {
+		defer close(fetches)
+		for i, block := range blocks {
+			if len(offsets[i]) == 0 {
+				continue
+			}
+			f := make(chan Fetch, 1)
+			go func(block api.Block, offsets, lengths []int64) {
+				b, err := s.Fetch(ctx, block, offsets, lengths)
+				f <- Fetch{b: b, err: err}
+			}(block, offsets[i], lengths[i])
+			select {
+			case fetches <- f:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return fetches
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestGetAhead(t *testing.T) {
+	const base, file = "mem://ahead", "mem://ahead"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKL")
+	var blocks []api.Block
+	for i := 0; i < len(data); i += 16 {
+		block := api.Block{ID: uuid.New(), Hash: api.Hash{byte(i)}, Size: 16}
+		_, err = s.Store(ctx, block.ID.String(), 16, bytes.NewReader(data[i:i+16]))
+		require.NoError(t, err)
+		blocks = append(blocks, block)
+	}
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Size: int64(len(data)), Blocks: blocks}, uuid.Nil, make([]bool, len(blocks)))
+	require.NoError(t, err)
+
+	b := &Block{Storage: s, Repository: r, Ahead: 2}
+	w := httptest.NewRecorder()
+	b.Get(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, data, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	q := httptest.NewRequest(http.MethodGet, "/a", nil)
+	q.Header.Set("Range", "bytes=40-45,2-5,8-20")
+	b.Get(w, q)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges"))
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	m := multipart.NewReader(w.Body, params["boundary"])
+	for _, part := range [][]byte{data[2:6], data[8:21], data[40:46]} {
+		p, err := m.NextPart()
+		require.NoError(t, err)
+		body, err := io.ReadAll(p)
+		require.NoError(t, err)
+		require.Equal(t, part, body)
+	}
+	_, err = m.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+
+	for _, block := range []api.Block{blocks[0], {Kind: api.KindInline, Payload: data[:16]}} {
+		p, err := b.Fetch(ctx, block, []int64{8, 2}, []int64{4, 3})
+		require.NoError(t, err)
+		require.Equal(t, data[2:12], p)
+	}
+	_, err = b.Fetch(ctx, api.Block{Kind: api.KindInline, Payload: data[:16]}, []int64{14, 2}, []int64{4, 3})
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"github.com/google/uuid"
 	"github.com/pshvedko/nocopy/api"
 	"github.com/pshvedko/nocopy/broker/exchange"
 	"github.com/pshvedko/nocopy/broker/message"
@@ -15,13 +16,19 @@ import (
 )
 
 func (s *Block) Head(w http.ResponseWriter, r *http.Request) {
-	reply, err := s.Broker.Request(r.Context(), "proxy", "head", message.NewBody(api.Head{Name: path.Clean(r.URL.Path)}),
-		exchange.WithTimeout(time.Minute))
+	head := api.Head{Name: path.Clean(r.URL.Path), Snapshot: r.URL.Query().Get(SnapshotQuery)}
+	reply, err := s.Broker.Request(r.Context(), "proxy", "head", message.NewBody(head), exchange.WithTimeout(time.Minute))
 	if err == nil {
 		var head api.HeadReply
 		err = reply.Decode(&head)
 		if err == nil {
-			w.Header().Set("Content-Length", strconv.FormatInt(head.GetLength(), 10))
+			w.Header().Set("Content-Length", strconv.FormatInt(head.Size, 10))
+			if head.Chain != uuid.Nil {
+				Describe(w.Header(), head.Object)
+				if len(head.Type) > 0 {
+					w.Header().Set("Content-Type", head.Type)
+				}
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -50,16 +57,21 @@ func (s *Chain) HeadQuery(ctx context.Context, m message.Message) (message.Body,
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("head", "name", head.Name)
-	name, date, length, blocks, sizes, err := s.Repository.Get(ctx, head.Name)
+	slog.Info("head", "name", head.Name, "snapshot", head.Snapshot)
+	var object api.Object
+	if len(head.Snapshot) > 0 {
+		object, err = s.Repository.View(ctx, head.Snapshot, head.Name)
+		if errors.Is(err, api.ErrNotExist) {
+			object, err = api.Object{Key: head.Name}, nil
+		}
+	} else {
+		object, err = s.Repository.Get(ctx, head.Name)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return message.NewBody(api.HeadReply{
-		Name:   name,
-		Time:   date,
-		Size:   length,
-		Blocks: blocks,
-		Sizes:  sizes,
-	}), nil
+	for i := range object.Blocks {
+		object.Blocks[i].Payload = nil
+	}
+	return message.NewBody(api.HeadReply{Object: object}), nil
 }
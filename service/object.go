@@ -0,0 +1,36 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+const MetadataPrefix = "X-Amz-Meta-"
+
+// Metadata collects the user metadata headers in lower case.
+func Metadata(h http.Header) map[string]string {
+	var metadata map[string]string
+	for k, v := range h {
+		key, ok := strings.CutPrefix(k, MetadataPrefix)
+		if !ok || len(v) == 0 {
+			continue
+		}
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[strings.ToLower(key)] = v[0]
+	}
+	return metadata
+}
+
+// Describe sets the object headers.
+func Describe(h http.Header, object api.Object) {
+	h.Set("ETag", object.ETag)
+	h.Set("Last-Modified", object.Modified.Format(time.RFC1123))
+	for k, v := range object.Metadata {
+		h.Set(MetadataPrefix+k, v)
+	}
+}
@@ -13,6 +13,7 @@ import (
 type Proxy struct {
 	broker.Broker
 	atomic.Bool
+	Webhook *Webhook
 }
 
 func (s *Proxy) Run(ctx context.Context, pipe string) error {
@@ -1,52 +1,372 @@
 package service
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/pshvedko/nocopy/api"
 	"github.com/pshvedko/nocopy/broker/message"
+	"github.com/pshvedko/nocopy/internal"
+	"github.com/pshvedko/nocopy/internal/chunk"
 	"github.com/pshvedko/nocopy/internal/io"
 )
 
+var (
+	ErrChunkMode = errors.New("invalid chunk mode")
+	ErrETag      = errors.New("invalid entity tag")
+)
+
+// Match parses If-Match into the chain expected by Repository.Update, only one strong tag or * is understood.
+func Match(header string) (uuid.UUID, error) {
+	switch header = strings.TrimSpace(header); header {
+	case "":
+		return uuid.Nil, nil
+	case "*":
+		return api.Any, nil
+	}
+	tag, ok := strings.CutPrefix(header, `"`)
+	if !ok {
+		return uuid.Nil, ErrETag
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return uuid.Nil, ErrETag
+	}
+	chain, err := uuid.Parse(tag)
+	if err != nil || chain == uuid.Nil || chain == api.Any {
+		return uuid.Nil, ErrETag
+	}
+	return chain, nil
+}
+
 func (s *Block) Put(w http.ResponseWriter, r *http.Request) {
-	var blocks []uuid.UUID
-	var hashes []api.Hash
-	var sizes []int64
-	file, err := s.Repository.Put(r.Context(), path.Clean(r.URL.Path))
-	for err == nil {
-		var size int64
-		bid := uuid.New()
-		hash := sha1.New()
-		//_, err = s.Storage.Store(r.Context(), bid.String(), -1, io.Compressor(r.Body, s.Size, &size, hash))
-		size, err = s.Storage.Store(r.Context(), bid.String(), -1, io.TeeLimitReader(r.Body, s.Size, hash))
+	var chains []uuid.UUID
+	name := path.Clean(r.URL.Path)
+	upload := uuid.New()
+	match, err := Match(r.Header.Get("If-Match"))
+	if err != nil {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		slog.Error("put", "upload", upload, "err", err)
+		return
+	}
+	err = s.Precondition(r.Context(), name, match)
+	if err != nil {
+		w.WriteHeader(internal.Ternary(errors.Is(err, api.ErrPrecondition), http.StatusPreconditionFailed, http.StatusInternalServerError))
+		slog.Error("put", "upload", upload, "err", err)
+		return
+	}
+	blocks, linked, total, err := s.Upload(r.Context(), r.Body)
+	if err == nil {
+		object := api.Object{
+			Key:      name,
+			Chain:    upload,
+			Type:     r.Header.Get("Content-Type"),
+			Metadata: Metadata(r.Header),
+			Blocks:   blocks,
+		}
+		chains, err = s.Repository.Update(r.Context(), object, match, linked)
+		if err == nil {
+			w.Header().Set("ETag", api.ETag(upload))
+			w.WriteHeader(http.StatusCreated)
+			ids, _ := api.Split(blocks)
+			Notify(r.Context(), s.Broker, api.Event{
+				Type:   internal.Ternary(len(chains) > 1, api.EventOverwritten, api.EventCreated),
+				Name:   name,
+				Time:   time.Now(),
+				Size:   total,
+				Chain:  chains[0],
+				Blocks: ids,
+			})
+			file := api.File{Name: name, Chains: chains, Algorithm: s.Algorithm}
+			for i, block := range blocks {
+				if linked[i] || block.Kind == api.KindInline {
+					continue
+				}
+				file.Blocks = append(file.Blocks, block.ID)
+				file.Hashes = append(file.Hashes, block.Hash)
+				file.Sizes = append(file.Sizes, block.Size)
+			}
+			_, err = s.Broker.Message(r.Context(), "proxy", "file", message.NewBody(file))
+			if err == nil {
+				return
+			}
+		}
+	}
+	if chains == nil {
+		s.Discard(context.WithoutCancel(r.Context()), blocks, linked)
+	}
+	w.WriteHeader(internal.Ternary(errors.Is(err, api.ErrPrecondition), http.StatusPreconditionFailed, http.StatusInternalServerError))
+	slog.Error("put", "upload", upload, "err", err)
+}
+
+// Precondition fails a stale If-Match before the upload, Repository.Update checks it again when publishing.
+func (s *Block) Precondition(ctx context.Context, name string, match uuid.UUID) error {
+	if match == uuid.Nil {
+		return nil
+	}
+	object, err := s.Repository.Get(ctx, name)
+	if errors.Is(err, api.ErrNotExist) {
+		return api.ErrPrecondition
+	} else if err != nil {
+		return err
+	}
+	if object.Chain == uuid.Nil || match != api.Any && object.Chain != match {
+		return api.ErrPrecondition
+	}
+	return nil
+}
+
+// Discard purges the objects stored by an upload that was never published, linked blocks belong to other files and
+// packs are shared with other uploads.
+func (s *Block) Discard(ctx context.Context, blocks []api.Block, linked []bool) {
+	for i, block := range blocks {
+		if linked[i] || block.Kind != api.KindStorage {
+			continue
+		}
+		err := s.Storage.Purge(ctx, block.ID.String())
 		if err != nil {
+			slog.Error("put", "id", block.ID, "err", err)
+		}
+	}
+}
+
+type Slot struct {
+	api.Block
+	linked bool
+	pack   *Pack
+}
+
+// Pack is an open pack object, uploads appending to it wait until it is stored.
+type Pack struct {
+	id   uuid.UUID
+	buf  bytes.Buffer
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Packer holds the pack shared by concurrent uploads.
+type Packer struct {
+	sync.Mutex
+	pack *Pack
+}
+
+func (s *Block) Upload(ctx context.Context, r io.Reader) (blocks []api.Block, linked []bool, total int64, err error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var g sync.WaitGroup
+	var slots []*Slot
+	q := make(chan struct{}, max(s.Parallel, 1))
+	r, x, err := s.Peek(r)
+	if err != nil {
+		return
+	}
+	if x != nil {
+		slots = append(slots, x)
+		total = x.Size
+	}
+	c, err := s.Chunker(r)
+	if err != nil {
+		return
+	}
+	for x == nil {
+		var b []byte
+		b, err = c.Next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			cancel(err)
 			break
 		}
-		blocks = append(blocks, bid)
-		hashes = append(hashes, hash.Sum([]byte{}))
-		sizes = append(sizes, size)
-		if size < s.Size {
-			var chains []uuid.UUID
-			chains, err = s.Repository.Update(r.Context(), file, blocks, hashes, sizes)
-			if err == nil {
-				w.WriteHeader(http.StatusCreated)
-				_, err = s.Broker.Message(r.Context(), "proxy", "file", message.NewBody(api.File{
-					Chains: chains,
-					Blocks: blocks,
-					Hashes: hashes,
-					Sizes:  sizes,
-				}))
-				if err == nil {
-					return
-				}
+		last := err != nil
+		hash := s.Algorithm.New()
+		_, _ = hash.Write(b)
+		x := &Slot{
+			Block: api.Block{
+				ID:        uuid.New(),
+				Hash:      hash.Sum([]byte{}),
+				Size:      int64(len(b)),
+				Algorithm: s.Algorithm,
+			},
+		}
+		slots = append(slots, x)
+		total += x.Size
+		select {
+		case q <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		g.Add(1)
+		go func() {
+			defer g.Done()
+			err := s.Save(ctx, x, b)
+			if err != nil {
+				cancel(err)
+			}
+			<-q
+		}()
+		if last {
+			break
+		}
+	}
+	g.Wait()
+	for _, x := range slots {
+		if x.pack == nil {
+			continue
+		}
+		select {
+		case <-x.pack.done:
+			if x.pack.err != nil {
+				cancel(x.pack.err)
+			}
+		case <-ctx.Done():
+		}
+	}
+	err = context.Cause(ctx)
+	for _, x := range slots {
+		blocks = append(blocks, x.Block)
+		linked = append(linked, x.linked)
+	}
+	return
+}
+
+func (s *Block) Peek(r io.Reader) (io.Reader, *Slot, error) {
+	if s.Small < 1 {
+		return r, nil, nil
+	}
+	b := make([]byte, s.Small+1)
+	n, err := io.ReadBytes(r, b)
+	switch {
+	case err == nil:
+		return io.MultiReader(bytes.NewReader(b), r), nil, nil
+	case !errors.Is(err, io.EOF):
+		return nil, nil, err
+	}
+	hash := s.Algorithm.New()
+	_, _ = hash.Write(b[:n])
+	return r, &Slot{
+		Block: api.Block{
+			ID:        uuid.New(),
+			Hash:      hash.Sum([]byte{}),
+			Size:      int64(n),
+			Algorithm: s.Algorithm,
+			Kind:      api.KindInline,
+			Payload:   b[:n:n],
+		},
+	}, nil
+}
+
+func (s *Block) Save(ctx context.Context, x *Slot, b []byte) error {
+	if s.Inline {
+		ok, err := s.Dedup(ctx, x, b)
+		if err != nil || ok {
+			return err
+		}
+	}
+	z, codec, err := s.Encode(b)
+	if err != nil {
+		return err
+	}
+	x.Codec = codec
+	if int64(len(z)) < s.Pack {
+		s.Append(ctx, x, z)
+		return nil
+	}
+	_, err = s.Storage.Store(ctx, x.ID.String(), int64(len(z)), bytes.NewReader(z))
+	return err
+}
+
+// Append writes the block into the open pack, the pack is stored once it fills or after Linger.
+func (s *Block) Append(ctx context.Context, x *Slot, z []byte) {
+	ctx = context.WithoutCancel(ctx)
+	s.packer.Lock()
+	p := s.packer.pack
+	if p == nil {
+		p = &Pack{id: uuid.New(), done: make(chan struct{})}
+		s.packer.pack = p
+		time.AfterFunc(s.Linger, func() { s.Seal(ctx, p) })
+	}
+	x.Kind = api.KindPacked
+	x.Pack = p.id
+	x.Offset = int64(p.buf.Len())
+	x.Length = int64(len(z))
+	x.pack = p
+	p.buf.Write(z)
+	full := int64(p.buf.Len()) >= s.Pack
+	s.packer.Unlock()
+	if full {
+		s.Seal(ctx, p)
+	}
+}
+
+// Seal closes the pack to further blocks and stores it.
+func (s *Block) Seal(ctx context.Context, p *Pack) {
+	p.once.Do(func() {
+		s.packer.Lock()
+		if s.packer.pack == p {
+			s.packer.pack = nil
+		}
+		s.packer.Unlock()
+		p.err = s.Flush(ctx, p.id, p.buf.Bytes())
+		close(p.done)
+	})
+}
+
+func (s *Block) Flush(ctx context.Context, id uuid.UUID, b []byte) error {
+	slog.Info("put", "pack", id, "size", len(b))
+	_, err := s.Storage.Store(ctx, id.String(), int64(len(b)), bytes.NewReader(b))
+	return err
+}
+
+func (s *Block) Dedup(ctx context.Context, x *Slot, b []byte) (bool, error) {
+	similarities, err := s.Repository.Lookup(ctx, s.Algorithm, x.Hash, x.Size)
+	if err != nil {
+		slog.Error("put", "hash", x.Hash, "err", err)
+		return false, nil
+	}
+	for _, similarity := range similarities {
+		if !s.Trust || !s.Algorithm.Strong() {
+			var similar io.ReadSeekCloser
+			similar, err = Open(ctx, s.Storage, s.Frozen, similarity)
+			if err != nil {
+				slog.Error("put", "id", similarity.ID, "err", err)
+				continue
+			}
+			var ok bool
+			ok, err = io.Compare(bytes.NewReader(b), similar)
+			_ = similar.Close()
+			if err != nil || !ok {
+				continue
 			}
 		}
+		slog.Info("put", "block", x.ID, "inline", similarity.ID)
+		similarity.Hash, similarity.Size, similarity.Algorithm = x.Hash, x.Size, x.Algorithm
+		x.Block = similarity
+		x.linked = true
+		return true, nil
+	}
+	return false, ctx.Err()
+}
+
+func (s *Block) Chunker(r io.Reader) (chunk.Chunker, error) {
+	switch s.Chunk {
+	case "fixed", "":
+		return chunk.NewFixed(r, int(s.Size))
+	case "cdc":
+		return chunk.NewFastCDC(r,
+			int(internal.Ternary(s.Min > 0, s.Min, s.Size/4)),
+			int(s.Size),
+			int(internal.Ternary(s.Max > 0, s.Max, s.Size*4)))
+	default:
+		return nil, ErrChunkMode
 	}
-	w.WriteHeader(http.StatusInternalServerError)
-	slog.Error("put", "err", err)
 }
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestPutAbort(t *testing.T) {
+	const base, file = "mem://abort", "mem://abort"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4}
+	_, err = s.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{block}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+
+	n := Count(t, s)
+	b := &Block{Storage: s, Repository: r, Algorithm: api.AlgorithmSHA256, Size: 16}
+	body := io.MultiReader(strings.NewReader(strings.Repeat("0123456789abcdef", 4)), iotest.ErrReader(errors.New("broken")))
+	w := httptest.NewRecorder()
+	b.Put(w, httptest.NewRequest(http.MethodPut, "/a", body))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	require.Equal(t, n, Count(t, s))
+	object, err := r.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Len(t, object.Blocks, 1)
+	require.Equal(t, block.ID, object.Blocks[0].ID)
+}
+
+type Slow struct {
+	storage.Storage
+	sync.Mutex
+	active int
+	peak   int
+}
+
+func (s *Slow) Store(ctx context.Context, name string, size int64, r io.Reader) (int64, error) {
+	s.Lock()
+	s.active++
+	s.peak = max(s.peak, s.active)
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		s.active--
+		s.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	return s.Storage.Store(ctx, name, size, r)
+}
+
+func TestUploadParallel(t *testing.T) {
+	const file = "mem://parallel"
+	ctx := context.TODO()
+	m, err := storage.New(file)
+	require.NoError(t, err)
+	s := &Slow{Storage: m}
+	data := strings.Repeat("0123456789abcdef", 7) + "01234567"
+	b := &Block{Storage: s, Algorithm: api.AlgorithmSHA256, Size: 16, Parallel: 4}
+	blocks, linked, total, err := b.Upload(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), total)
+	require.Len(t, blocks, 8)
+	require.Equal(t, make([]bool, 8), linked)
+	require.Greater(t, s.peak, 1)
+	require.LessOrEqual(t, s.peak, 4)
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		body, err := Open(ctx, m, nil, block)
+		require.NoError(t, err)
+		_, err = io.Copy(&buf, body)
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+	}
+	require.Equal(t, data, buf.String())
+
+	b.Parallel = 1
+	s.peak = 0
+	_, _, _, err = b.Upload(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 1, s.peak)
+}
+
+func TestUploadDedup(t *testing.T) {
+	const base, file = "mem://dedup", "mem://dedup"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	data := "0123456789abcdef0123"
+	b := &Block{Storage: s, Repository: r, Algorithm: api.AlgorithmSHA256, Size: 16, Inline: true}
+	blocks, linked, _, err := b.Upload(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, false}, linked)
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: blocks}, uuid.Nil, linked)
+	require.NoError(t, err)
+	n := Count(t, s)
+
+	again, linked, _, err := b.Upload(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, true}, linked)
+	require.Equal(t, blocks[0].ID, again[0].ID)
+	require.Equal(t, blocks[1].ID, again[1].ID)
+	require.Equal(t, n, Count(t, s))
+
+	hash := api.AlgorithmSHA256.New()
+	_, _ = hash.Write([]byte("fedcba9876"))
+	forged := api.Block{ID: uuid.New(), Hash: hash.Sum([]byte{}), Size: 10, Algorithm: api.AlgorithmSHA256}
+	_, err = s.Store(ctx, forged.ID.String(), 10, strings.NewReader("0123456789"))
+	require.NoError(t, err)
+	_, err = r.Update(ctx, api.Object{Key: "/b", Chain: uuid.New(), Blocks: []api.Block{forged}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+
+	blocks, linked, _, err = b.Upload(ctx, strings.NewReader("fedcba9876"))
+	require.NoError(t, err)
+	require.Equal(t, []bool{false}, linked)
+	require.NotEqual(t, forged.ID, blocks[0].ID)
+	b.Trust = true
+	blocks, linked, _, err = b.Upload(ctx, strings.NewReader("fedcba9876"))
+	require.NoError(t, err)
+	require.Equal(t, []bool{true}, linked)
+	require.Equal(t, forged.ID, blocks[0].ID)
+}
+
+func TestUploadInline(t *testing.T) {
+	const base, file = "mem://inline", "mem://inline"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	n := Count(t, s)
+	b := &Block{Storage: s, Repository: r, Algorithm: api.AlgorithmSHA256, Size: 16, Small: 8}
+	blocks, linked, total, err := b.Upload(ctx, strings.NewReader("01234567"))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), total)
+	require.Equal(t, []bool{false}, linked)
+	require.Equal(t, api.KindInline, blocks[0].Kind)
+	require.Equal(t, []byte("01234567"), blocks[0].Payload)
+	require.Equal(t, n, Count(t, s))
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: blocks}, uuid.Nil, linked)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	q := httptest.NewRequest(http.MethodGet, "/a", nil)
+	q.Header.Set("Range", "bytes=2-5")
+	b.Get(w, q)
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "2345", w.Body.String())
+
+	blocks, _, _, err = b.Upload(ctx, strings.NewReader("012345678"))
+	require.NoError(t, err)
+	require.Equal(t, api.KindStorage, blocks[0].Kind)
+	require.Equal(t, n+1, Count(t, s))
+
+}
+
+func TestUploadPack(t *testing.T) {
+	const file = "mem://pack"
+	ctx := context.TODO()
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	n := Count(t, s)
+	b := &Block{Storage: s, Algorithm: api.AlgorithmSHA256, Size: 16, Pack: 1024, Linger: 50 * time.Millisecond}
+	var g sync.WaitGroup
+	uploads := make([][]api.Block, 4)
+	for i := range uploads {
+		g.Add(1)
+		go func(i int) {
+			defer g.Done()
+			blocks, _, _, err := b.Upload(ctx, strings.NewReader(strings.Repeat(strconv.Itoa(i), 20)))
+			assert.NoError(t, err)
+			uploads[i] = blocks
+		}(i)
+	}
+	g.Wait()
+	require.Equal(t, n+1, Count(t, s))
+	for i, blocks := range uploads {
+		require.Len(t, blocks, 2)
+		for _, block := range blocks {
+			require.Equal(t, api.KindPacked, block.Kind)
+			require.Equal(t, uploads[0][0].Pack, block.Pack)
+		}
+		var buf bytes.Buffer
+		for _, block := range blocks {
+			body, err := Open(ctx, s, nil, block)
+			require.NoError(t, err)
+			_, err = io.Copy(&buf, body)
+			require.NoError(t, err)
+			require.NoError(t, body.Close())
+		}
+		require.Equal(t, strings.Repeat(strconv.Itoa(i), 20), buf.String())
+	}
+
+	b.Pack = 24
+	blocks, _, _, err := b.Upload(ctx, strings.NewReader(strings.Repeat("x", 40)))
+	require.NoError(t, err)
+	require.Len(t, blocks, 3)
+	require.Equal(t, blocks[0].Pack, blocks[1].Pack)
+	require.NotEqual(t, blocks[1].Pack, blocks[2].Pack)
+	require.Equal(t, n+3, Count(t, s))
+}
+
+func TestPutStale(t *testing.T) {
+	const base, file = "mem://stale", "mem://stale"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4}
+	_, err = s.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	chain := uuid.New()
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: chain, Blocks: []api.Block{block}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+
+	n := Count(t, s)
+	b := &Block{Storage: s, Repository: r, Algorithm: api.AlgorithmSHA256, Size: 16}
+	for _, c := range []struct{ name, match string }{{"/a", api.ETag(uuid.New())}, {"/b", "*"}, {"/b", api.ETag(chain)}} {
+		w := httptest.NewRecorder()
+		q := httptest.NewRequest(http.MethodPut, c.name, strings.NewReader(strings.Repeat("0123456789abcdef", 4)))
+		q.Header.Set("If-Match", c.match)
+		b.Put(w, q)
+		require.Equal(t, http.StatusPreconditionFailed, w.Code)
+	}
+	require.Equal(t, n, Count(t, s))
+	require.NoError(t, b.Precondition(ctx, "/a", chain))
+	require.NoError(t, b.Precondition(ctx, "/a", api.Any))
+}
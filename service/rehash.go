@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal/io"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+var ErrHashMismatch = errors.New("hash don't match")
+
+func (s *Chain) Digest(ctx context.Context, block api.Block, algorithm api.Algorithm) (api.Hash, error) {
+	var r io.Reader = bytes.NewReader(block.Payload)
+	if block.Kind != api.KindInline {
+		body, err := Open(ctx, s.Storage, s.Frozen, block)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = body.Close()
+		}()
+		r = body
+	}
+	h1 := block.Algorithm.New()
+	h2 := algorithm.New()
+	n, err := io.Copy(io.MultiWriter(h1, h2), r)
+	if err != nil {
+		return nil, err
+	}
+	if n != block.Size || !bytes.Equal(h1.Sum([]byte{}), block.Hash) {
+		return nil, ErrHashMismatch
+	}
+	return h2.Sum([]byte{}), nil
+}
+
+func (s *Chain) Rehash(ctx context.Context, base, file, hash string, batch int) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	algorithm, err := api.ParseAlgorithm(hash)
+	if err != nil {
+		return err
+	}
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	var after uuid.UUID
+	var done, failed int
+	for {
+		blocks, err := s.Repository.Scan(ctx, after, batch)
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+		for _, block := range blocks {
+			after = block.ID
+			if block.Algorithm == algorithm {
+				continue
+			}
+			l := slog.With("block", block.ID, "algorithm", block.Algorithm)
+			var sum api.Hash
+			sum, err = s.Digest(ctx, block, algorithm)
+			if err == nil {
+				err = s.Repository.Rehash(ctx, block.ID, algorithm, sum)
+			}
+			if err != nil {
+				l.Error("rehash", "err", err)
+				failed++
+				continue
+			}
+			l.Info("rehash", "hash", sum)
+			done++
+		}
+	}
+	slog.Info("rehash", "algorithm", algorithm, "done", done, "failed", failed)
+	return nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestRehash(t *testing.T) {
+	const base, file = "mem://rehash", "mem://rehash"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	sum1 := sha1.Sum([]byte("aaaa"))
+	good := api.Block{ID: uuid.New(), Hash: sum1[:], Size: 4, Algorithm: api.AlgorithmSHA1}
+	bad := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4, Algorithm: api.AlgorithmSHA1}
+	inline := api.Block{ID: uuid.New(), Hash: sum1[:], Size: 4, Algorithm: api.AlgorithmSHA1, Kind: api.KindInline, Payload: []byte("aaaa")}
+	for _, block := range []api.Block{good, bad} {
+		_, err = s.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+		require.NoError(t, err)
+	}
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{good, bad, inline}}, uuid.Nil, make([]bool, 3))
+	require.NoError(t, err)
+
+	require.NoError(t, (&Chain{}).Rehash(ctx, base, file, api.AlgorithmSHA256.String(), 1))
+	object, err := r.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Len(t, object.Blocks, 3)
+	sum256 := sha256.Sum256([]byte("aaaa"))
+	require.Equal(t, api.AlgorithmSHA256, object.Blocks[0].Algorithm)
+	require.Equal(t, api.Hash(sum256[:]), object.Blocks[0].Hash)
+	require.Equal(t, api.AlgorithmSHA1, object.Blocks[1].Algorithm)
+	require.Equal(t, bad.Hash, object.Blocks[1].Hash)
+	require.Equal(t, api.AlgorithmSHA256, object.Blocks[2].Algorithm)
+	require.Equal(t, api.Hash(sum256[:]), object.Blocks[2].Hash)
+}
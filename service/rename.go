@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+var ErrOverlap = errors.New("source and destination overlap")
+
+func init() {
+	chi.RegisterMethod("MOVE")
+}
+
+// Rename moves a file, or every file under a prefix, to another name without touching its blocks and reports whether
+// it overwrote any file. The blocks freed by the files it overwrites are purged from both tiers.
+func Rename(ctx context.Context, r repository.Publisher, hot, cold storage.Storage, from, to string, prefix, overwrite bool) (bool, error) {
+	if prefix {
+		from, to = strings.TrimSuffix(from, "/")+"/", strings.TrimSuffix(to, "/")+"/"
+	}
+	if from == to || prefix && (strings.HasPrefix(to, from) || strings.HasPrefix(from, to)) {
+		return false, ErrOverlap
+	}
+	blocks, replaced, err := r.Rename(ctx, from, to, prefix, overwrite)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range blocks {
+		slog.Info("rename", "id", id)
+		err = Purge(ctx, hot, cold, id.String())
+		if err != nil {
+			slog.Error("rename", "id", id, "err", err)
+		}
+	}
+	return replaced, nil
+}
+
+// Move is the WebDAV MOVE of a file, a source path ending with a slash moves every file under it. The destination is
+// overwritten unless the Overwrite header is F, overwriting it answers 204 instead of 201.
+func (s *Block) Move(w http.ResponseWriter, r *http.Request) {
+	from := path.Clean(r.URL.Path)
+	prefix := strings.HasSuffix(r.URL.Path, "/")
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || len(u.Path) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	to := path.Clean(u.Path)
+	replaced, err := Rename(r.Context(), s.Repository, s.Storage, s.Frozen, from, to, prefix, r.Header.Get("Overwrite") != "F")
+	switch {
+	case err == nil:
+		w.WriteHeader(internal.Ternary(replaced, http.StatusNoContent, http.StatusCreated))
+		Notify(r.Context(), s.Broker, api.Event{
+			Type: api.EventRenamed,
+			Name: to,
+			From: from,
+			Time: time.Now(),
+		})
+		return
+	case errors.Is(err, api.ErrNotExist):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, api.ErrExist):
+		w.WriteHeader(http.StatusPreconditionFailed)
+	case errors.Is(err, ErrOverlap):
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	slog.Error("move", "from", from, "to", to, "err", err)
+}
+
+func (s *Chain) Rename(ctx context.Context, base, file, from, to string, prefix, overwrite bool) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	from, to = path.Clean(from), path.Clean(to)
+	replaced, err := Rename(ctx, s.Repository, s.Storage, s.Frozen, from, to, prefix, overwrite)
+	if err != nil {
+		return err
+	}
+	slog.Info("rename", "from", from, "to", to, "prefix", prefix, "replaced", replaced)
+	return nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+var ErrNoRepair = errors.New("storage does not support repair")
+
+type Repairer interface {
+	Repair(context.Context, func(context.Context, []string) ([]string, error)) (int, error)
+}
+
+// Known filters the object names to the blocks and packs the repository still refers to.
+func (s *Chain) Known(ctx context.Context, names []string) (known []string, err error) {
+	var ids []uuid.UUID
+	for _, name := range names {
+		id, err := uuid.Parse(name)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	ids, err = s.Repository.Exist(ctx, ids)
+	for _, id := range ids {
+		known = append(known, id.String())
+	}
+	return
+}
+
+func (s *Chain) Repair(ctx context.Context, base, file string) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	r, ok := s.Storage.(Repairer)
+	if !ok {
+		return ErrNoRepair
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	n, err := r.Repair(ctx, s.Known)
+	if err != nil {
+		return err
+	}
+	slog.Info("repair", "repaired", n)
+	return nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestRepair(t *testing.T) {
+	const base, file = "mem://repair", "replica://?store=mem://repair-1&store=mem://repair-2"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s1, err := storage.New("mem://repair-1")
+	require.NoError(t, err)
+	s2, err := storage.New("mem://repair-2")
+	require.NoError(t, err)
+	block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4}
+	deleted := uuid.New()
+	for _, id := range []uuid.UUID{block.ID, deleted} {
+		_, err = s1.Store(ctx, id.String(), 4, bytes.NewBufferString("aaaa"))
+		require.NoError(t, err)
+	}
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{block}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+
+	require.NoError(t, (&Chain{}).Repair(ctx, base, file))
+	_, err = s2.Stat(ctx, block.ID.String())
+	require.NoError(t, err)
+	_, err = s2.Stat(ctx, deleted.String())
+	require.Error(t, err)
+}
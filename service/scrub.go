@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal/io"
+)
+
+// Scrubber walks all blocks in rounds, one block per Scrub interval, and re-verifies their hashes.
+func (s *Chain) Scrubber(ctx context.Context) {
+	t := time.NewTicker(s.Scrub)
+	defer t.Stop()
+	var after uuid.UUID
+	for {
+		blocks, err := s.Repository.Scan(ctx, after, max(s.Batch, 1))
+		if err != nil {
+			slog.Error("scrub", "err", err)
+		} else if len(blocks) == 0 {
+			slog.Info("scrub", "round", "done")
+			after = uuid.Nil
+		}
+		for _, block := range blocks {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			after = block.ID
+			s.Verify(ctx, block)
+		}
+		if len(blocks) > 0 {
+			continue
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Chain) Verify(ctx context.Context, block api.Block) {
+	l := slog.With("block", block.ID)
+	_, err := s.Digest(ctx, block, block.Algorithm)
+	corrupt := errors.Is(err, ErrHashMismatch) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrFrameFormat) || errors.Is(err, os.ErrNotExist)
+	if err != nil && !corrupt {
+		l.Error("scrub", "err", err)
+		return
+	}
+	err = s.Repository.Check(ctx, block.ID, corrupt)
+	if err != nil {
+		l.Error("scrub", "err", err)
+		return
+	}
+	if !corrupt {
+		return
+	}
+	names, err := s.Repository.Affected(ctx, block.ID)
+	if err != nil {
+		l.Error("scrub", "err", err)
+	}
+	l.Warn("scrub", "corrupt", true, "files", names)
+	for _, name := range names {
+		Notify(ctx, s.Broker, api.Event{
+			Type:   api.EventCorrupted,
+			Name:   name,
+			Time:   time.Now(),
+			Blocks: []uuid.UUID{block.ID},
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/broker"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+type Down struct {
+	storage.Storage
+	name string
+}
+
+func (s Down) Load(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	if name == s.name {
+		return nil, errors.New("down")
+	}
+	return s.Storage.Load(ctx, name)
+}
+
+func TestVerify(t *testing.T) {
+	const base, file, pipe = "mem://scrub", "mem://scrub", "mem://scrub"
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	b, err := broker.New(pipe, "chain/test/1")
+	require.NoError(t, err)
+	defer b.Shutdown()
+	b.UseMiddleware(Authorize{})
+	require.NoError(t, b.Listen(ctx, "chain", "test", "1"))
+	sum := sha256.Sum256([]byte("aaaa"))
+	blocks := make([]api.Block, 4)
+	for i := range blocks {
+		blocks[i] = api.Block{ID: uuid.New(), Hash: sum[:], Size: 4, Algorithm: api.AlgorithmSHA256}
+	}
+	good, mismatch, missing, unreachable := blocks[0], blocks[1], blocks[2], blocks[3]
+	for _, block := range []api.Block{good, unreachable} {
+		_, err = s.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+		require.NoError(t, err)
+	}
+	_, err = s.Store(ctx, mismatch.ID.String(), 4, bytes.NewBufferString("bbbb"))
+	require.NoError(t, err)
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: blocks}, uuid.Nil, make([]bool, len(blocks)))
+	require.NoError(t, err)
+
+	c := &Chain{Broker: b, Storage: Down{Storage: s, name: unreachable.ID.String()}, Repository: r}
+	for _, block := range blocks {
+		c.Verify(ctx, block)
+	}
+	similar, err := r.Lookup(ctx, api.AlgorithmSHA256, sum[:], 4)
+	require.NoError(t, err)
+	var ids []uuid.UUID
+	for _, block := range similar {
+		ids = append(ids, block.ID)
+	}
+	require.ElementsMatch(t, []uuid.UUID{good.ID, unreachable.ID}, ids)
+	require.NotContains(t, ids, mismatch.ID)
+	require.NotContains(t, ids, missing.ID)
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func Count(t *testing.T, s storage.Storage) int {
+	infos, err := s.List(context.TODO(), "", 1000)
+	require.NoError(t, err)
+	return len(infos)
+}
+
+func TestService(t *testing.T) {
+	const base, file, pipe = "mem://service", "mem://service", "mem://service"
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	p := &Proxy{}
+	c := &Chain{}
+	b := &Block{Hash: "sha256", Parallel: 2, Ahead: 2}
+	go func() { _ = p.Run(ctx, pipe) }()
+	go func() { _ = c.Run(ctx, base, file, pipe) }()
+	go func() { _ = b.Run(ctx, host, port, base, file, pipe, 16) }()
+	defer b.Stop()
+
+	client := http.Client{Timeout: time.Second}
+	do := func(method, name, rang string, body []byte) (int, []byte) {
+		r, err := http.NewRequestWithContext(ctx, method, "http://"+addr+name, bytes.NewReader(body))
+		require.NoError(t, err)
+		if rang != "" {
+			r.Header.Set("Range", rang)
+		}
+		w, err := client.Do(r)
+		require.NoError(t, err)
+		defer func() {
+			_ = w.Body.Close()
+		}()
+		p, err := io.ReadAll(w.Body)
+		require.NoError(t, err)
+		return w.StatusCode, p
+	}
+	require.Eventually(t, func() bool {
+		r, err := http.NewRequestWithContext(ctx, http.MethodHead, "http://"+addr+"/ready", nil)
+		require.NoError(t, err)
+		w, err := client.Do(r)
+		if err != nil {
+			return false
+		}
+		_ = w.Body.Close()
+		return w.StatusCode == http.StatusOK
+	}, 10*time.Second, 50*time.Millisecond)
+
+	s, err := storage.New(file)
+	require.NoError(t, err)
+	data := []byte(strings.Repeat("0123456789abcdef", 3) + "0123456789")
+
+	code, _ := do(http.MethodPut, "/a", "", data)
+	require.Equal(t, http.StatusCreated, code)
+	require.Eventually(t, func() bool { return Count(t, s) == 2 }, 10*time.Second, 10*time.Millisecond)
+	code, _ = do(http.MethodPut, "/b", "", data)
+	require.Equal(t, http.StatusCreated, code)
+	require.Eventually(t, func() bool { return Count(t, s) == 2 }, 10*time.Second, 10*time.Millisecond)
+
+	for _, name := range []string{"/a", "/b"} {
+		code, body := do(http.MethodGet, name, "", nil)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, data, body)
+		code, body = do(http.MethodGet, name, "bytes=10-41", nil)
+		require.Equal(t, http.StatusPartialContent, code)
+		require.Equal(t, data[10:42], body)
+	}
+
+	code, _ = do(http.MethodDelete, "/a", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodGet, "/a", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodDelete, "/a", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, body := do(http.MethodGet, "/b", "", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, data, body)
+	require.Equal(t, 2, Count(t, s))
+
+	put := func(name, match string) (int, string) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://"+addr+name, bytes.NewReader(data[:8]))
+		require.NoError(t, err)
+		if match != "" {
+			r.Header.Set("If-Match", match)
+		}
+		r.Header.Set("Content-Type", "text/plain")
+		r.Header.Set("X-Amz-Meta-Color", "red")
+		w, err := client.Do(r)
+		require.NoError(t, err)
+		_ = w.Body.Close()
+		return w.StatusCode, w.Header.Get("ETag")
+	}
+	code, _ = put("/c", "*")
+	require.Equal(t, http.StatusPreconditionFailed, code)
+	code, tag1 := put("/c", "")
+	require.Equal(t, http.StatusCreated, code)
+	code, tag2 := put("/c", tag1)
+	require.Equal(t, http.StatusCreated, code)
+	require.NotEqual(t, tag1, tag2)
+	code, _ = put("/c", tag1)
+	require.Equal(t, http.StatusPreconditionFailed, code)
+	code, tag3 := put("/c", "*")
+	require.Equal(t, http.StatusCreated, code)
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, "http://"+addr+"/c", nil)
+	require.NoError(t, err)
+	w, err := client.Do(r)
+	require.NoError(t, err)
+	_ = w.Body.Close()
+	require.Equal(t, http.StatusOK, w.StatusCode)
+	require.Equal(t, tag3, w.Header.Get("ETag"))
+	require.Equal(t, "text/plain", w.Header.Get("Content-Type"))
+	require.Equal(t, "red", w.Header.Get("X-Amz-Meta-Color"))
+	require.Equal(t, int64(8), w.ContentLength)
+	code, _ = do(http.MethodDelete, "/c", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+
+	move := func(name, destination, overwrite string) int {
+		r, err := http.NewRequestWithContext(ctx, "MOVE", "http://"+addr+name, nil)
+		require.NoError(t, err)
+		r.Header.Set("Destination", destination)
+		if overwrite != "" {
+			r.Header.Set("Overwrite", overwrite)
+		}
+		w, err := client.Do(r)
+		require.NoError(t, err)
+		_ = w.Body.Close()
+		return w.StatusCode
+	}
+	code, _ = do(http.MethodPut, "/m/1", "", data)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, http.StatusCreated, move("/m/", "http://"+addr+"/n/", ""))
+	code, _ = do(http.MethodGet, "/m/1", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, body = do(http.MethodGet, "/n/1", "", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, data, body)
+	require.Equal(t, http.StatusPreconditionFailed, move("/n/1", "/b", "F"))
+	require.Equal(t, http.StatusNotFound, move("/m/1", "/b", "F"))
+	require.Equal(t, http.StatusForbidden, move("/n/", "/n/o/", ""))
+	require.Equal(t, http.StatusCreated, move("/n/1", "/o", "F"))
+	code, _ = do(http.MethodPut, "/p", "", data[:10])
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, http.StatusNoContent, move("/o", "/p", ""))
+	code, body = do(http.MethodGet, "/p", "", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, data, body)
+	code, _ = do(http.MethodDelete, "/p", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+
+	repo, err := repository.New(base)
+	require.NoError(t, err)
+	code, _ = do(http.MethodPut, "/s/1", "", data)
+	require.Equal(t, http.StatusCreated, code)
+	snapshot, err := repo.Snapshot(ctx, "s1", Prefix("/s"))
+	require.NoError(t, err)
+	require.Equal(t, 1, snapshot.Files)
+	_, err = repo.Snapshot(ctx, "s1", Prefix("/s"))
+	require.ErrorIs(t, err, api.ErrExist)
+	code, _ = do(http.MethodDelete, "/s/1", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodGet, "/s/1", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, body = do(http.MethodGet, "/s/1?snapshot=s1", "", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, data, body)
+	code, _ = do(http.MethodGet, "/s/1?snapshot=s2", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodPut, "/s/1?snapshot=s1", "", data)
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	blocks, err := repo.Restore(ctx, "s1")
+	require.NoError(t, err)
+	Forget(ctx, s, nil, blocks)
+	code, body = do(http.MethodGet, "/s/1", "", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, data, body)
+	require.Equal(t, http.StatusCreated, move("/s/1", "/s/2", ""))
+	blocks, err = repo.Restore(ctx, "s1")
+	require.NoError(t, err)
+	Forget(ctx, s, nil, blocks)
+	for _, name := range []string{"/s/1", "/s/2"} {
+		code, body = do(http.MethodGet, name, "", nil)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, data, body)
+	}
+	head := func(name string) (int, string) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodHead, "http://"+addr+name, nil)
+		require.NoError(t, err)
+		w, err := client.Do(r)
+		require.NoError(t, err)
+		_ = w.Body.Close()
+		return w.StatusCode, w.Header.Get("Content-Length")
+	}
+	code, length := head("/s/2?snapshot=s1")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "0", length)
+	code, length = head("/s/1?snapshot=s1")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, strconv.Itoa(len(data)), length)
+	code, _ = do(http.MethodDelete, "/s/2", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+	blocks, err = repo.Release(ctx, "s1")
+	require.NoError(t, err)
+	Forget(ctx, s, nil, blocks)
+	code, _ = do(http.MethodGet, "/s/1?snapshot=s1", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodDelete, "/s/1", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+
+	code, _ = do(http.MethodDelete, "/b", "", nil)
+	require.Equal(t, http.StatusNoContent, code)
+	require.Eventually(t, func() bool { return Count(t, s) == 0 }, 10*time.Second, 10*time.Millisecond)
+	code, _ = do(http.MethodDelete, "/b", "", nil)
+	require.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+// SnapshotQuery selects the snapshot a GET or HEAD reads.
+const SnapshotQuery = "snapshot"
+
+// ReadOnly refuses writes to a snapshot.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.URL.Query().Has(SnapshotQuery) {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Prefix normalizes the prefix of a snapshot to a directory.
+func Prefix(prefix string) string {
+	return strings.TrimSuffix(path.Clean("/"+prefix), "/") + "/"
+}
+
+// Forget purges the unreferenced blocks from both tiers.
+func Forget(ctx context.Context, hot, cold storage.Storage, blocks []uuid.UUID) {
+	for _, id := range blocks {
+		slog.Info("snapshot", "id", id)
+		err := Purge(ctx, hot, cold, id.String())
+		if err != nil {
+			slog.Error("snapshot", "id", id, "err", err)
+		}
+	}
+}
+
+func (s *Chain) Snapshot(ctx context.Context, base, name, prefix string) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	snapshot, err := s.Repository.Snapshot(ctx, name, Prefix(prefix))
+	if err != nil {
+		return err
+	}
+	slog.Info("snapshot", "name", snapshot.Name, "prefix", snapshot.Prefix, "files", snapshot.Files)
+	return nil
+}
+
+func (s *Chain) Snapshots(ctx context.Context, base string) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	snapshots, err := s.Repository.Snapshots(ctx)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		slog.Info("snapshot", "name", snapshot.Name, "prefix", snapshot.Prefix, "files", snapshot.Files, "created", snapshot.Created)
+	}
+	return nil
+}
+
+// Restore brings back the files of the snapshot and keeps it.
+func (s *Chain) Restore(ctx context.Context, base, file, name string) error {
+	return s.Unpin(ctx, base, file, name, func(ctx context.Context, name string) ([]uuid.UUID, error) {
+		return s.Repository.Restore(ctx, name)
+	})
+}
+
+// Release deletes the snapshot and unpins its blocks.
+func (s *Chain) Release(ctx context.Context, base, file, name string) error {
+	return s.Unpin(ctx, base, file, name, func(ctx context.Context, name string) ([]uuid.UUID, error) {
+		return s.Repository.Release(ctx, name)
+	})
+}
+
+func (s *Chain) Unpin(ctx context.Context, base, file, name string, f func(context.Context, string) ([]uuid.UUID, error)) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	if len(s.Cold) > 0 {
+		s.Frozen, err = storage.New(s.Cold)
+		if err != nil {
+			return err
+		}
+		defer s.Frozen.Shutdown()
+	}
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	blocks, err := f(ctx, name)
+	if err != nil {
+		return err
+	}
+	Forget(ctx, s.Storage, s.Frozen, blocks)
+	slog.Info("snapshot", "name", name, "purged", len(blocks))
+	return nil
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/internal/io"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func Purge(ctx context.Context, hot, cold storage.Storage, name string) error {
+	err := hot.Purge(ctx, name)
+	if cold == nil {
+		return err
+	}
+	return errors.Join(err, cold.Purge(ctx, name))
+}
+
+// Move copies a block to another tier and records it there unless its tier has changed meanwhile. The copy left behind
+// is purged, the one in the target tier is kept unless the block is gone as then nobody else purges it.
+func Move(ctx context.Context, r repository.Mover, from, to storage.Storage, block api.Block, tier api.Tier) (bool, error) {
+	body, err := Probe(ctx, from, block.ID.String())
+	if err != nil {
+		return false, err
+	}
+	b, err := io.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		return false, err
+	}
+	_, err = to.Store(ctx, block.ID.String(), int64(len(b)), bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	moved, err := r.Move(ctx, block.ID, block.Tier, tier)
+	if err != nil {
+		return false, err
+	}
+	if moved {
+		return true, from.Purge(ctx, block.ID.String())
+	}
+	known, err := r.Exist(ctx, []uuid.UUID{block.ID})
+	if err == nil && len(known) == 0 {
+		err = to.Purge(ctx, block.ID.String())
+	}
+	return false, err
+}
+
+// Access records block reads once per Touch interval.
+func (s *Block) Access(ctx context.Context, blocks []api.Block) {
+	if s.Touch <= 0 {
+		return
+	}
+	now := time.Now()
+	var ids []uuid.UUID
+	for _, block := range blocks {
+		if block.Kind == api.KindInline {
+			continue
+		}
+		last, ok := s.touched.Load(block.ID)
+		if ok && now.Sub(last.(time.Time)) < s.Touch {
+			continue
+		}
+		s.touched.Store(block.ID, now)
+		ids = append(ids, block.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	go func() {
+		err := s.Repository.Touch(context.WithoutCancel(ctx), ids)
+		if err != nil {
+			slog.Error("touch", "err", err)
+		}
+	}()
+}
+
+// Warm moves a cold block back to the hot tier, reads racing with it find the block in the other tier.
+func (s *Block) Warm(ctx context.Context, block api.Block) {
+	if !s.Promote || s.Frozen == nil || block.Tier != api.TierCold || block.Kind != api.KindStorage {
+		return
+	}
+	if _, ok := s.warming.LoadOrStore(block.ID, true); ok {
+		return
+	}
+	go func() {
+		defer s.warming.Delete(block.ID)
+		l := slog.With("block", block.ID)
+		moved, err := Move(context.WithoutCancel(ctx), s.Repository, s.Frozen, s.Storage, block, api.TierHot)
+		if err != nil {
+			l.Error("promote", "err", err)
+			return
+		}
+		l.Info("promote", "moved", moved)
+	}()
+}
+
+func (s *Chain) Tiering(ctx context.Context, base, file, cold string, age time.Duration, batch int) error {
+	if !s.Bool.CompareAndSwap(false, true) {
+		return context.Canceled
+	}
+	var err error
+	s.Storage, err = storage.New(file)
+	if err != nil {
+		return err
+	}
+	defer s.Storage.Shutdown()
+	s.Frozen, err = storage.New(cold)
+	if err != nil {
+		return err
+	}
+	defer s.Frozen.Shutdown()
+	s.Repository, err = repository.New(base)
+	if err != nil {
+		return err
+	}
+	defer s.Repository.Shutdown()
+	var done, failed int
+	var skip = map[uuid.UUID]bool{}
+	before := time.Now().Add(-age)
+	for ctx.Err() == nil {
+		blocks, err := s.Repository.Stale(ctx, before, api.TierHot, batch+len(skip))
+		if err != nil {
+			return err
+		}
+		var n int
+		for _, block := range blocks {
+			if skip[block.ID] {
+				continue
+			}
+			n++
+			l := slog.With("block", block.ID)
+			var moved bool
+			moved, err = Move(ctx, s.Repository, s.Storage, s.Frozen, block, api.TierCold)
+			if err != nil {
+				l.Error("tier", "err", err)
+				skip[block.ID] = true
+				failed++
+				continue
+			}
+			l.Info("tier", "moved", moved)
+			done++
+		}
+		if n == 0 {
+			break
+		}
+	}
+	slog.Info("tier", "age", age, "done", done, "failed", failed)
+	return ctx.Err()
+}
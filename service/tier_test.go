@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/repository"
+	"github.com/pshvedko/nocopy/storage"
+)
+
+func TestTiering(t *testing.T) {
+	const base, hot, cold = "mem://tier", "mem://tier-hot", "mem://tier-cold"
+	ctx := context.TODO()
+	r, err := repository.New(base)
+	require.NoError(t, err)
+	h, err := storage.New(hot)
+	require.NoError(t, err)
+	c, err := storage.New(cold)
+	require.NoError(t, err)
+	block := api.Block{ID: uuid.New(), Hash: api.Hash{1}, Size: 4}
+	_, err = h.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	_, err = r.Update(ctx, api.Object{Key: "/a", Chain: uuid.New(), Blocks: []api.Block{block}}, uuid.Nil, []bool{false})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	require.NoError(t, (&Chain{}).Tiering(ctx, base, hot, cold, 0, 10))
+	object, err := r.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, api.TierCold, object.Blocks[0].Tier)
+	_, err = Probe(ctx, h, block.ID.String())
+	require.Error(t, err)
+
+	for _, b := range []api.Block{block, object.Blocks[0]} {
+		body, err := Open(ctx, h, c, b)
+		require.NoError(t, err)
+		p, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Equal(t, "aaaa", string(p))
+	}
+
+	_, err = h.Store(ctx, block.ID.String(), 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	moved, err := Move(ctx, r, h, c, block, api.TierCold)
+	require.NoError(t, err)
+	require.False(t, moved)
+	_, err = Probe(ctx, c, block.ID.String())
+	require.NoError(t, err)
+	require.NoError(t, h.Purge(ctx, block.ID.String()))
+
+	gone := api.Block{ID: uuid.New(), Hash: api.Hash{2}, Size: 4}
+	_, err = h.Store(ctx, gone.ID.String(), 4, bytes.NewBufferString("bbbb"))
+	require.NoError(t, err)
+	moved, err = Move(ctx, r, h, c, gone, api.TierCold)
+	require.NoError(t, err)
+	require.False(t, moved)
+	_, err = Probe(ctx, c, gone.ID.String())
+	require.Error(t, err)
+
+	b := &Block{Storage: h, Frozen: c, Repository: r, Promote: true}
+	for i := 0; i < 4; i++ {
+		b.Warm(ctx, object.Blocks[0])
+	}
+	require.Eventually(t, func() bool {
+		object, err = r.Get(ctx, "/a")
+		require.NoError(t, err)
+		return object.Blocks[0].Tier == api.TierHot
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, ok := b.warming.Load(block.ID)
+		return !ok
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err = Probe(ctx, h, block.ID.String())
+	require.NoError(t, err)
+	_, err = Probe(ctx, c, block.ID.String())
+	require.Error(t, err)
+}
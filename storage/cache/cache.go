@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Storage interface {
+	Store(context.Context, string, int64, io.Reader) (int64, error)
+	Load(context.Context, string) (io.ReadSeekCloser, error)
+	LoadRange(context.Context, string, int64, int64) (io.ReadCloser, error)
+	Stat(context.Context, string) (api.Info, error)
+	List(context.Context, string, int) ([]api.Info, error)
+	Purge(context.Context, string) error
+	Shutdown()
+}
+
+type Reader struct {
+	*bytes.Reader
+}
+
+func (r Reader) Close() error { return nil }
+
+type Entry struct {
+	name string
+	size int64
+	data []byte
+}
+
+type Stats struct {
+	Hits   uint64  `json:"hits"`
+	Misses uint64  `json:"misses"`
+	Rate   float64 `json:"rate"`
+	Items  int     `json:"items"`
+	Size   int64   `json:"size"`
+	Limit  int64   `json:"limit"`
+}
+
+type Cache struct {
+	Storage
+	mutex  sync.Mutex
+	list   list.List
+	index  map[string]*list.Element
+	dir    string
+	size   int64
+	limit  int64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *Cache) Load(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	r, ok := c.Get(name)
+	if ok {
+		c.hits.Add(1)
+		return r, nil
+	}
+	c.misses.Add(1)
+	body, err := c.Storage.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	c.Put(name, b)
+	return Reader{Reader: bytes.NewReader(b)}, nil
+}
+
+// LoadRange reads the range from the whole object, which a miss loads into the cache.
+func (c *Cache) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := c.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return Limit{Reader: io.LimitReader(r, length), Closer: r}, nil
+}
+
+type Limit struct {
+	io.Reader
+	io.Closer
+}
+
+func (c *Cache) Purge(ctx context.Context, name string) error {
+	c.mutex.Lock()
+	e, ok := c.index[name]
+	if ok {
+		c.Remove(e)
+	}
+	c.mutex.Unlock()
+	return c.Storage.Purge(ctx, name)
+}
+
+func (c *Cache) Get(name string) (io.ReadSeekCloser, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.index[name]
+	if !ok {
+		return nil, false
+	}
+	if c.dir == "" {
+		c.list.MoveToFront(e)
+		return Reader{Reader: bytes.NewReader(e.Value.(*Entry).data)}, true
+	}
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		c.Remove(e)
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return f, true
+}
+
+func (c *Cache) Put(name string, data []byte) {
+	size := int64(len(data))
+	if size > c.limit {
+		return
+	}
+	x := &Entry{name: name, size: size}
+	if c.dir == "" {
+		x.data = data
+	} else if c.Write(name, data) != nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.index[name]; ok {
+		return
+	}
+	c.Add(x)
+}
+
+func (c *Cache) Add(x *Entry) {
+	c.index[x.name] = c.list.PushFront(x)
+	c.size += x.size
+	for c.size > c.limit {
+		c.Remove(c.list.Back())
+	}
+}
+
+func (c *Cache) Remove(e *list.Element) {
+	x := c.list.Remove(e).(*Entry)
+	delete(c.index, x.name)
+	c.size -= x.size
+	if c.dir != "" {
+		_ = os.Remove(filepath.Join(c.dir, x.name))
+	}
+}
+
+func (c *Cache) Write(name string, data []byte) error {
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (c *Cache) Scan() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		name string
+		size int64
+		time time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		if name[0] == '.' {
+			_ = os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{name: name, size: info.Size(), time: info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b file) int {
+		return a.time.Compare(b.time)
+	})
+	for _, f := range files {
+		c.Add(&Entry{name: f.name, size: f.size})
+	}
+	return nil
+}
+
+func (c *Cache) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s := Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Items:  c.list.Len(),
+		Size:   c.size,
+		Limit:  c.limit,
+	}
+	if s.Hits+s.Misses > 0 {
+		s.Rate = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	return s
+}
+
+func (c *Cache) Metrics() any {
+	return c.Stats()
+}
+
+func New(s Storage, dir string, limit int64) (*Cache, error) {
+	c := &Cache{
+		Storage: s,
+		index:   map[string]*list.Element{},
+		dir:     dir,
+		limit:   limit,
+	}
+	if dir == "" {
+		return c, nil
+	}
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Scan()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
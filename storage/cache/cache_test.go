@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Backend struct {
+	blobs map[string][]byte
+	loads int
+}
+
+func (b *Backend) Store(_ context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	p, err := io.ReadAll(r)
+	b.blobs[name] = p
+	return int64(len(p)), err
+}
+
+func (b *Backend) Load(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	p, ok := b.blobs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	b.loads++
+	return Reader{Reader: bytes.NewReader(p)}, nil
+}
+
+func (b *Backend) Purge(_ context.Context, name string) error {
+	delete(b.blobs, name)
+	return nil
+}
+
+func (b *Backend) List(_ context.Context, after string, limit int) (infos []api.Info, err error) {
+	for name, p := range b.blobs {
+		if name > after {
+			infos = append(infos, api.Info{Name: name, Size: int64(len(p))})
+		}
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+func (b *Backend) Stat(_ context.Context, name string) (api.Info, error) {
+	p, ok := b.blobs[name]
+	if !ok {
+		return api.Info{}, os.ErrNotExist
+	}
+	return api.Info{Name: name, Size: int64(len(p))}, nil
+}
+
+func (b *Backend) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := b.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	return io.NopCloser(io.LimitReader(r, length)), err
+}
+
+func (b *Backend) Shutdown() {}
+
+func TestCache(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		t.Run(dir, func(t *testing.T) {
+			ctx := context.TODO()
+			b := &Backend{blobs: map[string][]byte{}}
+			c, err := New(b, dir, 8)
+			require.NoError(t, err)
+			for _, name := range []string{"a", "b", "c"} {
+				_, err = c.Store(ctx, name, 4, bytes.NewBufferString(name+name+name+name))
+				require.NoError(t, err)
+			}
+			load := func(name string) string {
+				r, err := c.Load(ctx, name)
+				require.NoError(t, err)
+				defer func() {
+					require.NoError(t, r.Close())
+				}()
+				p, err := io.ReadAll(r)
+				require.NoError(t, err)
+				return string(p)
+			}
+			require.Equal(t, "aaaa", load("a"))
+			require.Equal(t, "aaaa", load("a"))
+			require.Equal(t, 1, b.loads)
+			require.Equal(t, "bbbb", load("b"))
+			require.Equal(t, "cccc", load("c"))
+			require.Equal(t, "bbbb", load("b"))
+			require.Equal(t, 3, b.loads)
+			require.Equal(t, "aaaa", load("a"))
+			require.Equal(t, 4, b.loads)
+			require.NoError(t, c.Purge(ctx, "a"))
+			_, err = c.Load(ctx, "a")
+			require.ErrorIs(t, err, os.ErrNotExist)
+			s := c.Stats()
+			require.Equal(t, uint64(2), s.Hits)
+			require.Equal(t, uint64(5), s.Misses)
+			require.Equal(t, 1, s.Items)
+			require.Equal(t, int64(4), s.Size)
+		})
+	}
+}
+
+func TestCacheRange(t *testing.T) {
+	ctx := context.TODO()
+	b := &Backend{blobs: map[string][]byte{}}
+	c, err := New(b, "", 8)
+	require.NoError(t, err)
+	for _, name := range []string{"a", "large"} {
+		_, err = c.Store(ctx, name, 4, bytes.NewBufferString(strings.Repeat(name, 2)))
+		require.NoError(t, err)
+	}
+	load := func(name string, offset, length int64) string {
+		r, err := c.LoadRange(ctx, name, offset, length)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, r.Close())
+		}()
+		p, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(p)
+	}
+	require.Equal(t, "a", load("a", 1, 1))
+	require.Equal(t, "aa", load("a", 0, 4))
+	require.Equal(t, 1, b.loads)
+	require.Equal(t, "gela", load("large", 3, 4))
+	require.Equal(t, "arg", load("large", 1, 3))
+	require.Equal(t, 3, b.loads)
+	s := c.Stats()
+	require.Equal(t, uint64(1), s.Hits)
+	require.Equal(t, uint64(3), s.Misses)
+	require.Equal(t, 1, s.Items)
+	require.Equal(t, int64(2), s.Size)
+}
@@ -0,0 +1,368 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+var ErrShards = errors.New("not enough shards")
+var ErrShardFormat = errors.New("invalid shard format")
+var ErrQuorum = errors.New("write quorum not reached")
+var ErrInvQuorum = errors.New("write quorum out of range")
+
+// Shard layout: uint64 object size, uint32 crc32 of the shard data, then the data.
+const header = 8 + 4
+
+type Storage interface {
+	Store(context.Context, string, int64, io.Reader) (int64, error)
+	Load(context.Context, string) (io.ReadSeekCloser, error)
+	LoadRange(context.Context, string, int64, int64) (io.ReadCloser, error)
+	Stat(context.Context, string) (api.Info, error)
+	List(context.Context, string, int) ([]api.Info, error)
+	Purge(context.Context, string) error
+	Shutdown()
+}
+
+type Reader struct {
+	*bytes.Reader
+}
+
+func (r Reader) Close() error { return nil }
+
+// Erasure keeps shard i of every object on backend i, any data shards out of data+parity restore it. A store succeeds
+// once write shards are stored, all of them if write is 0.
+type Erasure struct {
+	stores  []Storage
+	data    int
+	write   int
+	encoder reedsolomon.Encoder
+}
+
+func (s *Erasure) Store(ctx context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	shards, err := s.Encode(b)
+	if err != nil {
+		return 0, err
+	}
+	errs := s.Each(func(i int, store Storage) error {
+		p := Seal(shards[i], int64(len(b)))
+		_, err := store.Store(ctx, name, int64(len(p)), bytes.NewReader(p))
+		return err
+	})
+	var n int
+	for i, err := range errs {
+		if err != nil {
+			slog.Warn("erasure", "name", name, "shard", i, "err", err)
+			continue
+		}
+		n++
+	}
+	if n < s.write {
+		return 0, errors.Join(append(errs, ErrQuorum)...)
+	}
+	return int64(len(b)), nil
+}
+
+func (s *Erasure) Load(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	shards, size, err := s.Shards(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return Reader{Reader: bytes.NewReader(nil)}, nil
+	}
+	err = s.encoder.ReconstructData(shards)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = s.encoder.Join(&buf, shards, int(size))
+	if err != nil {
+		return nil, err
+	}
+	return Reader{Reader: bytes.NewReader(buf.Bytes())}, nil
+}
+
+func (s *Erasure) Purge(ctx context.Context, name string) error {
+	return errors.Join(s.Each(func(_ int, store Storage) error {
+		return store.Purge(ctx, name)
+	})...)
+}
+
+// Stat reads the object size from a shard header.
+func (s *Erasure) Stat(ctx context.Context, name string) (api.Info, error) {
+	var errs []error
+	for _, store := range s.stores {
+		info, err := store.Stat(ctx, name)
+		if err == nil {
+			var r io.ReadCloser
+			r, err = store.LoadRange(ctx, name, 0, header)
+			if err == nil {
+				var p [header]byte
+				_, err = io.ReadFull(r, p[:])
+				_ = r.Close()
+				if err == nil {
+					info.Size = int64(binary.BigEndian.Uint64(p[:]))
+					return info, nil
+				}
+			}
+		}
+		errs = append(errs, err)
+	}
+	return api.Info{}, errors.Join(errs...)
+}
+
+// LoadRange rebuilds the whole object.
+func (s *Erasure) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := s.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return Limit{Reader: io.LimitReader(r, length), Closer: r}, nil
+}
+
+type Limit struct {
+	io.Reader
+	io.Closer
+}
+
+// List reports the sizes of the shards, Stat reads the size of an object.
+func (s *Erasure) List(ctx context.Context, after string, limit int) ([]api.Info, error) {
+	seen := map[string]api.Info{}
+	for _, store := range s.stores {
+		infos, err := store.List(ctx, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if _, ok := seen[info.Name]; !ok {
+				seen[info.Name] = info
+			}
+		}
+	}
+	infos := make([]api.Info, 0, len(seen))
+	for _, info := range seen {
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+// Repair rebuilds shards missing on some backend from the remaining ones, as long as known still reports the object.
+func (s *Erasure) Repair(ctx context.Context, known func(context.Context, []string) ([]string, error)) (repaired int, err error) {
+	names := make([]map[string]struct{}, len(s.stores))
+	union := map[string]struct{}{}
+	for i, store := range s.stores {
+		names[i] = map[string]struct{}{}
+		err = Walk(ctx, store, func(info api.Info) error {
+			names[i][info.Name] = struct{}{}
+			union[info.Name] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	var list []string
+	lagging := map[string][]int{}
+	for name := range union {
+		for i := range s.stores {
+			if _, ok := names[i][name]; !ok {
+				lagging[name] = append(lagging[name], i)
+			}
+		}
+		if len(lagging[name]) > 0 {
+			list = append(list, name)
+		}
+	}
+	slices.Sort(list)
+	for len(list) > 0 && ctx.Err() == nil {
+		var keep []string
+		keep, err = known(ctx, list[:min(len(list), 1000)])
+		if err != nil {
+			return
+		}
+		list = list[min(len(list), 1000):]
+		for _, name := range keep {
+			missing := lagging[name]
+			l := slog.With("name", name, "shards", missing)
+			err = s.Rebuild(ctx, name, missing)
+			if err != nil {
+				l.Error("repair", "err", err)
+				continue
+			}
+			l.Info("repair")
+			repaired += len(missing)
+		}
+	}
+	return repaired, ctx.Err()
+}
+
+func Walk(ctx context.Context, store Storage, f func(api.Info) error) error {
+	var after string
+	for {
+		infos, err := store.List(ctx, after, 1000)
+		if err != nil || len(infos) == 0 {
+			return err
+		}
+		for _, info := range infos {
+			err = f(info)
+			if err != nil {
+				return err
+			}
+		}
+		after = infos[len(infos)-1].Name
+	}
+}
+
+func (s *Erasure) Rebuild(ctx context.Context, name string, missing []int) error {
+	shards, size, err := s.Shards(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, i := range missing {
+		shards[i] = nil
+	}
+	if size > 0 {
+		err = s.encoder.Reconstruct(shards)
+		if err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, i := range missing {
+		p := Seal(shards[i], size)
+		_, err = s.stores[i].Store(ctx, name, int64(len(p)), bytes.NewReader(p))
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Erasure) Encode(b []byte) ([][]byte, error) {
+	if len(b) == 0 {
+		shards := make([][]byte, len(s.stores))
+		for i := range shards {
+			shards[i] = []byte{}
+		}
+		return shards, nil
+	}
+	shards, err := s.encoder.Split(b)
+	if err != nil {
+		return nil, err
+	}
+	return shards, s.encoder.Encode(shards)
+}
+
+// Shards loads all shards in parallel, missing or damaged ones are left nil.
+func (s *Erasure) Shards(ctx context.Context, name string) ([][]byte, int64, error) {
+	shards := make([][]byte, len(s.stores))
+	sizes := make([]int64, len(s.stores))
+	errs := s.Each(func(i int, store Storage) (err error) {
+		shards[i], sizes[i], err = Open(ctx, store, name)
+		return
+	})
+	var n int
+	var size int64
+	for i, err := range errs {
+		if err != nil {
+			slog.Warn("erasure", "name", name, "shard", i, "err", err)
+			shards[i] = nil
+			continue
+		}
+		size = sizes[i]
+		n++
+	}
+	if n < s.data {
+		return nil, 0, errors.Join(append(errs, ErrShards)...)
+	}
+	return shards, size, nil
+}
+
+func (s *Erasure) Each(f func(int, Storage) error) []error {
+	errs := make([]error, len(s.stores))
+	var g sync.WaitGroup
+	for i, store := range s.stores {
+		g.Add(1)
+		go func(i int, store Storage) {
+			defer g.Done()
+			errs[i] = f(i, store)
+		}(i, store)
+	}
+	g.Wait()
+	return errs
+}
+
+func (s *Erasure) Shutdown() {
+	for _, store := range s.stores {
+		store.Shutdown()
+	}
+}
+
+func Seal(shard []byte, size int64) []byte {
+	p := make([]byte, header, header+len(shard))
+	binary.BigEndian.PutUint64(p, uint64(size))
+	binary.BigEndian.PutUint32(p[8:], crc32.ChecksumIEEE(shard))
+	return append(p, shard...)
+}
+
+func Open(ctx context.Context, store Storage, name string) ([]byte, int64, error) {
+	r, err := store.Load(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(p) < header || binary.BigEndian.Uint32(p[8:]) != crc32.ChecksumIEEE(p[header:]) {
+		return nil, 0, ErrShardFormat
+	}
+	return p[header:], int64(binary.BigEndian.Uint64(p)), nil
+}
+
+func New(stores []Storage, data, write int) (*Erasure, error) {
+	if data < 1 || data >= len(stores) {
+		return nil, reedsolomon.ErrInvShardNum
+	}
+	switch {
+	case write == 0:
+		write = len(stores)
+	case write < data || write > len(stores):
+		return nil, ErrInvQuorum
+	}
+	encoder, err := reedsolomon.New(data, len(stores)-data)
+	if err != nil {
+		return nil, err
+	}
+	return &Erasure{
+		stores:  stores,
+		data:    data,
+		write:   write,
+		encoder: encoder,
+	}, nil
+}
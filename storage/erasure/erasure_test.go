@@ -0,0 +1,151 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Backend struct {
+	blobs map[string][]byte
+	down  bool
+}
+
+func (b *Backend) Store(_ context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	if b.down {
+		return 0, errors.New("down")
+	}
+	p, err := io.ReadAll(r)
+	b.blobs[name] = p
+	return int64(len(p)), err
+}
+
+func (b *Backend) Load(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	p, ok := b.blobs[name]
+	if !ok || b.down {
+		return nil, os.ErrNotExist
+	}
+	return Reader{Reader: bytes.NewReader(p)}, nil
+}
+
+func (b *Backend) Purge(_ context.Context, name string) error {
+	delete(b.blobs, name)
+	return nil
+}
+
+func (b *Backend) List(_ context.Context, after string, limit int) (infos []api.Info, err error) {
+	for name, p := range b.blobs {
+		if name > after {
+			infos = append(infos, api.Info{Name: name, Size: int64(len(p))})
+		}
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+func (b *Backend) Stat(_ context.Context, name string) (api.Info, error) {
+	p, ok := b.blobs[name]
+	if !ok || b.down {
+		return api.Info{}, os.ErrNotExist
+	}
+	return api.Info{Name: name, Size: int64(len(p))}, nil
+}
+
+func (b *Backend) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := b.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	return io.NopCloser(io.LimitReader(r, length)), err
+}
+
+func (b *Backend) Shutdown() {}
+
+func TestErasure(t *testing.T) {
+	ctx := context.TODO()
+	var stores []Storage
+	var b []*Backend
+	for i := 0; i < 6; i++ {
+		b = append(b, &Backend{blobs: map[string][]byte{}})
+		stores = append(stores, b[i])
+	}
+	e, err := New(stores, 4, 0)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	for _, size := range []int{0, 1, 10, 10000} {
+		_, err = e.Store(ctx, "a", int64(size), bytes.NewReader(data[:size]))
+		require.NoError(t, err)
+		b[0].down = true
+		b[3].blobs["a"][8] ^= 0xff
+		r, err := e.Load(ctx, "a")
+		require.NoError(t, err)
+		p, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data[:size], p)
+		b[0].down = false
+	}
+	b[0].down = true
+	b[1].down = true
+	_, err = e.Load(ctx, "a")
+	require.ErrorIs(t, err, ErrShards)
+	b[0].down = false
+	b[1].down = false
+	delete(b[2].blobs, "a")
+	delete(b[3].blobs, "a")
+	n, err := e.Repair(ctx, func(context.Context, []string) ([]string, error) { return nil, nil })
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.NotContains(t, b[2].blobs, "a")
+	n, err = e.Repair(ctx, func(_ context.Context, names []string) ([]string, error) { return names, nil })
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	b[4].down = true
+	b[5].down = true
+	r, err := e.Load(ctx, "a")
+	require.NoError(t, err)
+	p, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, p)
+}
+
+func TestErasureQuorum(t *testing.T) {
+	ctx := context.TODO()
+	var stores []Storage
+	var b []*Backend
+	for i := 0; i < 6; i++ {
+		b = append(b, &Backend{blobs: map[string][]byte{}})
+		stores = append(stores, b[i])
+	}
+	b[0].down = true
+	for _, write := range []int{-1, 3, 7} {
+		_, err := New(stores, 4, write)
+		require.ErrorIs(t, err, ErrInvQuorum)
+	}
+	e, err := New(stores, 4, 0)
+	require.NoError(t, err)
+	_, err = e.Store(ctx, "a", 4, bytes.NewBufferString("aaaa"))
+	require.ErrorIs(t, err, ErrQuorum)
+	e, err = New(stores, 4, 4)
+	require.NoError(t, err)
+	_, err = e.Store(ctx, "a", 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	e, err = New(stores, 4, 5)
+	require.NoError(t, err)
+	_, err = e.Store(ctx, "a", 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	b[1].down = true
+	_, err = e.Store(ctx, "a", 4, bytes.NewBufferString("aaaa"))
+	require.ErrorIs(t, err, ErrQuorum)
+}
@@ -0,0 +1,159 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Storage struct {
+	path string
+}
+
+func (s *Storage) Shutdown() {}
+
+// Path shards objects by name prefix, so 0a1b2c... lands in 0a/1b/0a1b2c...
+func (s *Storage) Path(name string) string {
+	dir := s.path
+	for i := 0; i < 4 && i+2 < len(name); i += 2 {
+		dir = filepath.Join(dir, name[i:i+2])
+	}
+	return filepath.Join(dir, name)
+}
+
+func (s *Storage) Purge(_ context.Context, name string) error {
+	err := os.Remove(s.Path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *Storage) Load(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *Storage) Store(_ context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	p := s.Path(name)
+	dir := filepath.Dir(p)
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return 0, err
+	}
+	return n, Sync(dir)
+}
+
+var errPage = errors.New("page is full")
+
+// List walks the shard tree in name order.
+func (s *Storage) List(ctx context.Context, after string, limit int) (infos []api.Info, err error) {
+	skip := strings.Split(filepath.Dir(s.Path(after)), string(filepath.Separator))
+	root := len(strings.Split(s.path, string(filepath.Separator)))
+	err = filepath.WalkDir(s.path, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e.IsDir() {
+			parts := strings.Split(p, string(filepath.Separator))
+			if i := len(parts) - 1; i >= root && i < len(skip) && parts[i] < skip[i] && slices.Equal(parts[root:i], skip[root:i]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") || e.Name() <= after {
+			return nil
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		infos = append(infos, api.Info{Name: e.Name(), Size: info.Size(), Time: info.ModTime()})
+		if len(infos) == limit {
+			return errPage
+		}
+		return nil
+	})
+	if errors.Is(err, errPage) {
+		err = nil
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return
+}
+
+func (s *Storage) Stat(_ context.Context, name string) (api.Info, error) {
+	info, err := os.Stat(s.Path(name))
+	if err != nil {
+		return api.Info{}, err
+	}
+	return api.Info{Name: name, Size: info.Size(), Time: info.ModTime()}, nil
+}
+
+func (s *Storage) LoadRange(_ context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return Section{SectionReader: io.NewSectionReader(f, offset, length), Closer: f}, nil
+}
+
+type Section struct {
+	*io.SectionReader
+	io.Closer
+}
+
+func Sync(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	_ = d.Close()
+	return err
+}
+
+func New(u *url.URL) (*Storage, error) {
+	err := os.MkdirAll(u.Path, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{path: u.Path}, nil
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.TODO()
+	dir := t.TempDir()
+	s, err := New(&url.URL{Scheme: "file", Path: dir})
+	require.NoError(t, err)
+	name := "0a1b2c3d-0000-0000-0000-000000000000"
+	n, err := s.Store(ctx, name, 10, bytes.NewBufferString("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n)
+	require.FileExists(t, filepath.Join(dir, "0a", "1b", name))
+	r, err := s.Load(ctx, name)
+	require.NoError(t, err)
+	require.IsType(t, &os.File{}, r)
+	_, err = r.Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "56789", string(b))
+	require.NoError(t, r.Close())
+	_, err = s.Store(ctx, "ff"+name[2:], 1, bytes.NewBufferString("f"))
+	require.NoError(t, err)
+	infos, err := s.List(ctx, "", 1)
+	require.NoError(t, err)
+	require.Equal(t, []api.Info{{Name: name, Size: 10, Time: infos[0].Time}}, infos)
+	infos, err = s.List(ctx, name, 10)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "ff"+name[2:], infos[0].Name)
+	info, err := s.Stat(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), info.Size)
+	rc, err := s.LoadRange(ctx, name, 3, 4)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "3456", string(b))
+	require.NoError(t, rc.Close())
+	require.NoError(t, s.Purge(ctx, name))
+	require.NoError(t, s.Purge(ctx, name))
+	_, err = s.Load(ctx, name)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
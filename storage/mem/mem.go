@@ -0,0 +1,104 @@
+package mem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Reader struct {
+	*bytes.Reader
+}
+
+func (r Reader) Close() error { return nil }
+
+type Object struct {
+	data     []byte
+	modified time.Time
+}
+
+// Storage keeps objects in memory, it is shared by every New of the same host.
+type Storage struct {
+	sync.Mutex
+	objects map[string]Object
+}
+
+func (s *Storage) Shutdown() {}
+
+func (s *Storage) Purge(_ context.Context, name string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *Storage) Load(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	o, ok := s.objects[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return Reader{Reader: bytes.NewReader(o.data)}, nil
+}
+
+func (s *Storage) Store(_ context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.objects[name] = Object{data: b, modified: time.Now()}
+	return int64(len(b)), nil
+}
+
+func (s *Storage) List(_ context.Context, after string, limit int) (infos []api.Info, err error) {
+	s.Lock()
+	defer s.Unlock()
+	for name, o := range s.objects {
+		if name > after {
+			infos = append(infos, api.Info{Name: name, Size: int64(len(o.data)), Time: o.modified})
+		}
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+func (s *Storage) Stat(_ context.Context, name string) (api.Info, error) {
+	s.Lock()
+	defer s.Unlock()
+	o, ok := s.objects[name]
+	if !ok {
+		return api.Info{}, fs.ErrNotExist
+	}
+	return api.Info{Name: name, Size: int64(len(o.data)), Time: o.modified}, nil
+}
+
+func (s *Storage) LoadRange(_ context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	o, ok := s.objects[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	offset = min(offset, int64(len(o.data)))
+	return Reader{Reader: bytes.NewReader(o.data[offset:min(offset+length, int64(len(o.data)))])}, nil
+}
+
+var storages sync.Map
+
+func New(u *url.URL) (*Storage, error) {
+	s, _ := storages.LoadOrStore(u.Host, &Storage{objects: map[string]Object{}})
+	return s.(*Storage), nil
+}
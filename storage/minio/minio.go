@@ -2,11 +2,15 @@ package minio
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/fs"
 	"net/url"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/pshvedko/nocopy/api"
 )
 
 type Storage struct {
@@ -16,14 +20,67 @@ type Storage struct {
 
 func (s *Storage) Shutdown() {}
 
+// Convert maps a missing object to fs.ErrNotExist like the other storages.
+func Convert(err error) error {
+	var e minio.ErrorResponse
+	if errors.As(err, &e) && e.Code == "NoSuchKey" {
+		return errors.Join(fs.ErrNotExist, err)
+	}
+	return err
+}
+
+// Open sends the request of a lazy object so a missing one fails before the first read.
+func Open(r *minio.Object, err error) (io.ReadSeekCloser, error) {
+	if err != nil {
+		return nil, Convert(err)
+	}
+	_, err = r.Stat()
+	if err != nil {
+		_ = r.Close()
+		return nil, Convert(err)
+	}
+	return r, nil
+}
+
 func (s *Storage) Purge(ctx context.Context, name string) error {
 	err := s.client.RemoveObject(ctx, s.path[1:], name, minio.RemoveObjectOptions{})
 	return err
 }
 
+func (s *Storage) List(ctx context.Context, after string, limit int) (infos []api.Info, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for info := range s.client.ListObjects(ctx, s.path[1:], minio.ListObjectsOptions{Recursive: true, StartAfter: after, MaxKeys: limit}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		infos = append(infos, api.Info{Name: info.Key, Size: info.Size, Time: info.LastModified})
+		if len(infos) == limit {
+			break
+		}
+	}
+	return
+}
+
+func (s *Storage) Stat(ctx context.Context, name string) (api.Info, error) {
+	info, err := s.client.StatObject(ctx, s.path[1:], name, minio.StatObjectOptions{})
+	if err != nil {
+		return api.Info{}, Convert(err)
+	}
+	return api.Info{Name: info.Key, Size: info.Size, Time: info.LastModified}, nil
+}
+
+func (s *Storage) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	var options minio.GetObjectOptions
+	err := options.SetRange(offset, offset+length-1)
+	if err != nil {
+		return nil, err
+	}
+	return Open(s.client.GetObject(ctx, s.path[1:], name, options))
+}
+
 func (s *Storage) Load(ctx context.Context, name string) (io.ReadSeekCloser, error) {
-	r, err := s.client.GetObject(ctx, s.path[1:], name, minio.GetObjectOptions{})
-	return r, err
+	return Open(s.client.GetObject(ctx, s.path[1:], name, minio.GetObjectOptions{}))
 }
 
 func (s *Storage) Store(ctx context.Context, name string, size int64, r io.Reader) (int64, error) {
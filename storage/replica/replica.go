@@ -0,0 +1,255 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+var ErrQuorum = errors.New("write quorum not reached")
+
+type Storage interface {
+	Store(context.Context, string, int64, io.Reader) (int64, error)
+	Load(context.Context, string) (io.ReadSeekCloser, error)
+	LoadRange(context.Context, string, int64, int64) (io.ReadCloser, error)
+	Stat(context.Context, string) (api.Info, error)
+	List(context.Context, string, int) ([]api.Info, error)
+	Purge(context.Context, string) error
+	Shutdown()
+}
+
+// Replica writes every object to all backends and succeeds once Write of them have it.
+type Replica struct {
+	stores []Storage
+	write  int
+}
+
+func (s *Replica) Store(ctx context.Context, name string, size int64, r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	errs := make([]error, len(s.stores))
+	var g sync.WaitGroup
+	for i, store := range s.stores {
+		g.Add(1)
+		go func(i int, store Storage) {
+			defer g.Done()
+			_, errs[i] = store.Store(ctx, name, int64(len(b)), bytes.NewReader(b))
+		}(i, store)
+	}
+	g.Wait()
+	var n int
+	for i, err := range errs {
+		if err != nil {
+			slog.Warn("replica", "name", name, "store", i, "err", err)
+			continue
+		}
+		n++
+	}
+	if n < s.write {
+		return 0, errors.Join(append(errs, ErrQuorum)...)
+	}
+	return int64(len(b)), nil
+}
+
+func (s *Replica) Load(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	var errs []error
+	for i, store := range s.stores {
+		r, err := s.Open(ctx, store, name)
+		if err == nil {
+			return r, nil
+		}
+		slog.Warn("replica", "name", name, "store", i, "err", err)
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Open loads an object and probes it, lazy readers only fail on first access.
+func (s *Replica) Open(ctx context.Context, store Storage, name string) (io.ReadSeekCloser, error) {
+	r, err := store.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = r.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Replica) Purge(ctx context.Context, name string) error {
+	var errs []error
+	for _, store := range s.stores {
+		errs = append(errs, store.Purge(ctx, name))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Replica) Stat(ctx context.Context, name string) (api.Info, error) {
+	var errs []error
+	for _, store := range s.stores {
+		info, err := store.Stat(ctx, name)
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, err)
+	}
+	return api.Info{}, errors.Join(errs...)
+}
+
+func (s *Replica) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	var errs []error
+	for i, store := range s.stores {
+		_, err := store.Stat(ctx, name)
+		if err == nil {
+			var r io.ReadCloser
+			r, err = store.LoadRange(ctx, name, offset, length)
+			if err == nil {
+				return r, nil
+			}
+		}
+		slog.Warn("replica", "name", name, "store", i, "err", err)
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// List merges the pages of all backends.
+func (s *Replica) List(ctx context.Context, after string, limit int) ([]api.Info, error) {
+	seen := map[string]api.Info{}
+	for _, store := range s.stores {
+		infos, err := store.List(ctx, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if _, ok := seen[info.Name]; !ok {
+				seen[info.Name] = info
+			}
+		}
+	}
+	infos := make([]api.Info, 0, len(seen))
+	for _, info := range seen {
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+// Repair copies every object missing on some backend from a backend that has it, as long as known still reports it.
+func (s *Replica) Repair(ctx context.Context, known func(context.Context, []string) ([]string, error)) (repaired int, err error) {
+	names := make([]map[string]struct{}, len(s.stores))
+	union := map[string]int{}
+	for i, store := range s.stores {
+		names[i] = map[string]struct{}{}
+		err = Walk(ctx, store, func(info api.Info) error {
+			names[i][info.Name] = struct{}{}
+			if _, ok := union[info.Name]; !ok {
+				union[info.Name] = i
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	var lagging []string
+	for name := range union {
+		for i := range s.stores {
+			if _, ok := names[i][name]; !ok {
+				lagging = append(lagging, name)
+				break
+			}
+		}
+	}
+	slices.Sort(lagging)
+	for len(lagging) > 0 && ctx.Err() == nil {
+		var keep []string
+		keep, err = known(ctx, lagging[:min(len(lagging), 1000)])
+		if err != nil {
+			return
+		}
+		lagging = lagging[min(len(lagging), 1000):]
+		for _, name := range keep {
+			from := union[name]
+			for i, store := range s.stores {
+				if _, ok := names[i][name]; ok {
+					continue
+				}
+				l := slog.With("name", name, "from", from, "to", i)
+				err = s.Copy(ctx, s.stores[from], store, name)
+				if err != nil {
+					l.Error("repair", "err", err)
+					continue
+				}
+				l.Info("repair")
+				repaired++
+			}
+		}
+	}
+	return repaired, ctx.Err()
+}
+
+func Walk(ctx context.Context, store Storage, f func(api.Info) error) error {
+	var after string
+	for {
+		infos, err := store.List(ctx, after, 1000)
+		if err != nil || len(infos) == 0 {
+			return err
+		}
+		for _, info := range infos {
+			err = f(info)
+			if err != nil {
+				return err
+			}
+		}
+		after = infos[len(infos)-1].Name
+	}
+}
+
+func (s *Replica) Copy(ctx context.Context, from, to Storage, name string) error {
+	r, err := s.Open(ctx, from, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = to.Store(ctx, name, int64(len(b)), bytes.NewReader(b))
+	return err
+}
+
+func (s *Replica) Shutdown() {
+	for _, store := range s.stores {
+		store.Shutdown()
+	}
+}
+
+func New(stores []Storage, write int) *Replica {
+	if write < 1 || write > len(stores) {
+		write = len(stores)
+	}
+	return &Replica{
+		stores: stores,
+		write:  write,
+	}
+}
@@ -0,0 +1,116 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pshvedko/nocopy/api"
+)
+
+type Reader struct {
+	*bytes.Reader
+}
+
+func (r Reader) Close() error { return nil }
+
+type Backend struct {
+	blobs map[string][]byte
+	down  bool
+}
+
+func (b *Backend) Store(_ context.Context, name string, _ int64, r io.Reader) (int64, error) {
+	if b.down {
+		return 0, errors.New("down")
+	}
+	p, err := io.ReadAll(r)
+	b.blobs[name] = p
+	return int64(len(p)), err
+}
+
+func (b *Backend) Load(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	p, ok := b.blobs[name]
+	if !ok || b.down {
+		return nil, os.ErrNotExist
+	}
+	return Reader{Reader: bytes.NewReader(p)}, nil
+}
+
+func (b *Backend) Purge(_ context.Context, name string) error {
+	delete(b.blobs, name)
+	return nil
+}
+
+func (b *Backend) List(_ context.Context, after string, limit int) (infos []api.Info, err error) {
+	for name, p := range b.blobs {
+		if name > after {
+			infos = append(infos, api.Info{Name: name, Size: int64(len(p))})
+		}
+	}
+	slices.SortFunc(infos, func(a, b api.Info) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos[:min(limit, len(infos))], nil
+}
+
+func (b *Backend) Stat(_ context.Context, name string) (api.Info, error) {
+	p, ok := b.blobs[name]
+	if !ok || b.down {
+		return api.Info{}, os.ErrNotExist
+	}
+	return api.Info{Name: name, Size: int64(len(p))}, nil
+}
+
+func (b *Backend) LoadRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := b.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(offset, io.SeekStart)
+	return io.NopCloser(io.LimitReader(r, length)), err
+}
+
+func (b *Backend) Shutdown() {}
+
+func TestReplica(t *testing.T) {
+	ctx := context.TODO()
+	b := []*Backend{{blobs: map[string][]byte{}}, {blobs: map[string][]byte{}}, {blobs: map[string][]byte{}}}
+	r := New([]Storage{b[0], b[1], b[2]}, 2)
+	b[2].down = true
+	_, err := r.Store(ctx, "a", 4, bytes.NewBufferString("aaaa"))
+	require.NoError(t, err)
+	b[1].down = true
+	_, err = r.Store(ctx, "b", 4, bytes.NewBufferString("bbbb"))
+	require.ErrorIs(t, err, ErrQuorum)
+	b[0].down = true
+	b[1].down = false
+	l, err := r.Load(ctx, "a")
+	require.NoError(t, err)
+	p, err := io.ReadAll(l)
+	require.NoError(t, err)
+	require.Equal(t, "aaaa", string(p))
+	b[0].down = false
+	b[2].down = false
+	n, err := r.Repair(ctx, func(_ context.Context, names []string) ([]string, error) {
+		return slices.DeleteFunc(names, func(name string) bool { return name == "b" }), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []byte("aaaa"), b[2].blobs["a"])
+	require.NotContains(t, b[1].blobs, "b")
+	n, err = r.Repair(ctx, func(_ context.Context, names []string) ([]string, error) { return names, nil })
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []byte("bbbb"), b[1].blobs["b"])
+	require.NoError(t, r.Purge(ctx, "a"))
+	for i := range b {
+		require.NotContains(t, b[i].blobs, "a")
+	}
+}
@@ -5,13 +5,23 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"strconv"
 
+	"github.com/pshvedko/nocopy/api"
+	"github.com/pshvedko/nocopy/storage/cache"
+	"github.com/pshvedko/nocopy/storage/erasure"
+	"github.com/pshvedko/nocopy/storage/file"
+	"github.com/pshvedko/nocopy/storage/mem"
 	"github.com/pshvedko/nocopy/storage/minio"
+	"github.com/pshvedko/nocopy/storage/replica"
 )
 
 type Storage interface {
 	Store(context.Context, string, int64, io.Reader) (int64, error)
 	Load(context.Context, string) (io.ReadSeekCloser, error)
+	LoadRange(context.Context, string, int64, int64) (io.ReadCloser, error)
+	Stat(context.Context, string) (api.Info, error)
+	List(context.Context, string, int) ([]api.Info, error)
 	Purge(context.Context, string) error
 	Shutdown()
 }
@@ -24,7 +34,79 @@ func New(name string) (Storage, error) {
 	switch u.Scheme {
 	case "minio":
 		return minio.New(u)
+	case "file":
+		return file.New(u)
+	case "mem":
+		return mem.New(u)
+	case "replica":
+		return NewReplica(u)
+	case "erasure":
+		return NewErasure(u)
 	default:
 		return nil, errors.New("invalid storage scheme")
 	}
 }
+
+// NewReplica composes the backends given as repeated store parameters, replica://?write=2&store=...&store=...
+func NewReplica(u *url.URL) (*replica.Replica, error) {
+	var stores []replica.Storage
+	for _, name := range u.Query()["store"] {
+		s, err := New(name)
+		if err != nil {
+			for _, s := range stores {
+				s.Shutdown()
+			}
+			return nil, err
+		}
+		stores = append(stores, s)
+	}
+	if len(stores) == 0 {
+		return nil, errors.New("no replica stores")
+	}
+	write, _ := strconv.Atoi(u.Query().Get("write"))
+	return replica.New(stores, write), nil
+}
+
+// NewErasure spreads data+parity shards over the repeated store parameters, erasure://?data=4&write=5&store=...&store=...
+func NewErasure(u *url.URL) (*erasure.Erasure, error) {
+	var stores []erasure.Storage
+	for _, name := range u.Query()["store"] {
+		s, err := New(name)
+		if err != nil {
+			for _, s := range stores {
+				s.Shutdown()
+			}
+			return nil, err
+		}
+		stores = append(stores, s)
+	}
+	data, _ := strconv.Atoi(u.Query().Get("data"))
+	write, _ := strconv.Atoi(u.Query().Get("write"))
+	e, err := erasure.New(stores, data, write)
+	if err != nil {
+		for _, s := range stores {
+			s.Shutdown()
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+func NewCache(s Storage, name string) (*cache.Cache, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(u.Query().Get("size"), 10, 64)
+	if err != nil {
+		size = 64 << 20
+	}
+	switch u.Scheme {
+	case "mem":
+		return cache.New(s, "", size)
+	case "file":
+		return cache.New(s, u.Path, size)
+	default:
+		return nil, errors.New("invalid cache scheme")
+	}
+}